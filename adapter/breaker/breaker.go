@@ -0,0 +1,495 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package breaker wraps a remote cache adapter with per-operation
+// deadlines and a circuit breaker, so a slow or failing backend costs
+// requests a bounded delay and, once the circuit opens, none at all: the
+// middleware bypasses the cache until a probe call succeeds again.
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	cache "github.com/victorspringer/http-cache"
+)
+
+// State is the state of the circuit.
+type State int
+
+const (
+	// StateClosed lets every call reach the backend.
+	StateClosed State = iota
+
+	// StateOpen fails every call with ErrOpen until the cooldown
+	// elapses.
+	StateOpen
+
+	// StateHalfOpen lets a single probe call reach the backend: its
+	// success closes the circuit, its failure opens it again.
+	StateHalfOpen
+)
+
+// String returns the state name.
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+}
+
+// ErrOpen is returned by calls refused because the circuit is open. It
+// wraps cache.ErrAdapterUnavailable, so the middleware serves the
+// request from the origin without using the cache.
+var ErrOpen = fmt.Errorf("breaker: circuit open: %w", cache.ErrAdapterUnavailable)
+
+// errSlow is the failure recorded for a call that succeeded but took
+// longer than the latency threshold.
+var errSlow = errors.New("breaker: call exceeded the latency threshold")
+
+// errNoWideKeys is returned by the wide key methods when the wrapped
+// adapter does not implement cache.WideKeyAdapter.
+var errNoWideKeys = errors.New("breaker: wrapped adapter does not take wide keys")
+
+// errAbandoned is returned, and counted as a failure, instead of calling
+// an adapter that already holds too many calls abandoned at the timeout.
+var errAbandoned = errors.New("breaker: too many calls abandoned to the backend")
+
+const (
+	defaultTimeout          = 250 * time.Millisecond
+	defaultFailureThreshold = 5
+	defaultCooldown         = 10 * time.Second
+	defaultMaxAbandoned     = 64
+)
+
+// Adapter is the circuit breaker adapter data structure.
+type Adapter struct {
+	adapter cache.Adapter
+	next    cache.ContextAdapter
+	direct  bool
+
+	timeout      time.Duration
+	slow         time.Duration
+	threshold    int
+	cooldown     time.Duration
+	maxAbandoned int64
+	abandoned    atomic.Int64
+
+	mutex    sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	probing  bool
+	notify   []func(cache.CacheEvent)
+}
+
+// AdapterOptions is used to set Adapter settings.
+type AdapterOptions func(a *Adapter) error
+
+// Get implements the cache Adapter interface Get method.
+func (a *Adapter) Get(key uint64) ([]byte, bool) {
+	b, ok, _ := a.GetContext(context.Background(), key)
+	return b, ok
+}
+
+// Set implements the cache Adapter interface Set method.
+func (a *Adapter) Set(key uint64, response []byte, expiration time.Time) {
+	a.SetContext(context.Background(), key, response, expiration)
+}
+
+// Release implements the cache Adapter interface Release method.
+func (a *Adapter) Release(key uint64) {
+	a.ReleaseContext(context.Background(), key)
+}
+
+// GetContext implements the cache.ContextAdapter interface. It returns
+// ErrOpen while the circuit is open and context.DeadlineExceeded when
+// the backend does not answer within the timeout.
+func (a *Adapter) GetContext(ctx context.Context, key uint64) ([]byte, bool, error) {
+	var (
+		b  []byte
+		ok bool
+	)
+	err := a.call(ctx, func(ctx context.Context) error {
+		var err error
+		b, ok, err = a.next.GetContext(ctx, key)
+		return err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return b, ok, nil
+}
+
+// SetContext implements the cache.ContextAdapter interface.
+func (a *Adapter) SetContext(ctx context.Context, key uint64, response []byte, expiration time.Time) error {
+	return a.call(ctx, func(ctx context.Context) error {
+		return a.next.SetContext(ctx, key, response, expiration)
+	})
+}
+
+// ReleaseContext implements the cache.ContextAdapter interface.
+func (a *Adapter) ReleaseContext(ctx context.Context, key uint64) error {
+	return a.call(ctx, func(ctx context.Context) error {
+		return a.next.ReleaseContext(ctx, key)
+	})
+}
+
+// GetWide implements the cache.WideKeyAdapter interface for a wrapped
+// adapter implementing it, guarded like GetContext.
+func (a *Adapter) GetWide(ctx context.Context, key string) ([]byte, bool, error) {
+	wa, ok := a.adapter.(cache.WideKeyAdapter)
+	if !ok {
+		return nil, false, errNoWideKeys
+	}
+	var b []byte
+	err := a.call(ctx, func(ctx context.Context) error {
+		var err error
+		b, ok, err = wa.GetWide(ctx, key)
+		return err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return b, ok, nil
+}
+
+// SetWide implements the cache.WideKeyAdapter interface.
+func (a *Adapter) SetWide(ctx context.Context, key string, response []byte, expiration time.Time) error {
+	wa, ok := a.adapter.(cache.WideKeyAdapter)
+	if !ok {
+		return errNoWideKeys
+	}
+	return a.call(ctx, func(ctx context.Context) error {
+		return wa.SetWide(ctx, key, response, expiration)
+	})
+}
+
+// ReleaseWide implements the cache.WideKeyAdapter interface.
+func (a *Adapter) ReleaseWide(ctx context.Context, key string) error {
+	wa, ok := a.adapter.(cache.WideKeyAdapter)
+	if !ok {
+		return errNoWideKeys
+	}
+	return a.call(ctx, func(ctx context.Context) error {
+		return wa.ReleaseWide(ctx, key)
+	})
+}
+
+// Unwrap implements the cache.AdapterWrapper optional interface, so the
+// client reaches the optional interfaces of the wrapped adapter.
+func (a *Adapter) Unwrap() cache.Adapter {
+	return a.adapter
+}
+
+// Notify implements the cache.AdapterNotifier optional interface. fn
+// receives a circuit event on each state change, and the events of the
+// wrapped adapter when it is a notifier too.
+func (a *Adapter) Notify(fn func(cache.CacheEvent)) {
+	a.mutex.Lock()
+	a.notify = append(a.notify, fn)
+	a.mutex.Unlock()
+
+	if n, ok := a.adapter.(cache.AdapterNotifier); ok {
+		n.Notify(fn)
+	}
+}
+
+// State returns the current state of the circuit. An open circuit whose
+// cooldown has elapsed is reported open until a call probes the backend.
+func (a *Adapter) State() State {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.state
+}
+
+// call runs op against the backend if the circuit allows it, bounded by
+// the timeout, and records the outcome.
+func (a *Adapter) call(ctx context.Context, op func(context.Context) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	probe, err := a.allow()
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err = a.run(ctx, op)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		// The caller gave up: that says nothing about the backend.
+		a.record(probe, nil, true)
+	case err != nil:
+		a.record(probe, err, false)
+	case a.slow > 0 && time.Since(start) > a.slow:
+		a.record(probe, errSlow, false)
+	default:
+		a.record(probe, nil, false)
+	}
+	return err
+}
+
+// run calls op with the timeout applied. op runs in its own goroutine,
+// left to finish in the background when the deadline passes, since the
+// adapter may not return before it is done; once maxAbandoned of them are
+// pending, calls fail right away instead of piling up on a stalled
+// backend. With AdapterWithDirectCalls, op is called directly instead and
+// an answer coming after the deadline still fails the call.
+func (a *Adapter) run(ctx context.Context, op func(context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	if a.direct {
+		if err := op(ctx); err != nil {
+			return err
+		}
+		return ctx.Err()
+	}
+
+	if a.abandoned.Load() >= a.maxAbandoned {
+		return errAbandoned
+	}
+	const (
+		running int32 = iota
+		finished
+		abandoned
+	)
+	var state atomic.Int32
+	done := make(chan error, 1)
+	go func() {
+		done <- op(ctx)
+		if !state.CompareAndSwap(running, finished) {
+			a.abandoned.Add(-1)
+		}
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		if state.CompareAndSwap(running, abandoned) {
+			a.abandoned.Add(1)
+		}
+		return ctx.Err()
+	}
+}
+
+// allow reports whether a call may reach the backend, and whether it is
+// the half-open probe.
+func (a *Adapter) allow() (bool, error) {
+	var (
+		probe  bool
+		err    error
+		events []cache.CacheEvent
+	)
+	a.mutex.Lock()
+	switch a.state {
+	case StateOpen:
+		if time.Since(a.openedAt) < a.cooldown {
+			err = ErrOpen
+			break
+		}
+		a.state = StateHalfOpen
+		events = append(events, cache.CacheEvent{Type: cache.CacheEventCircuit, Reason: cache.ReasonCircuitHalfOpen})
+		fallthrough
+	case StateHalfOpen:
+		if a.probing {
+			err = ErrOpen
+			break
+		}
+		a.probing = true
+		probe = true
+	}
+	notify := a.notify
+	a.mutex.Unlock()
+
+	emit(notify, events)
+	return probe, err
+}
+
+// record updates the circuit with the outcome of a call: failure is nil
+// on success, and ignored calls only give back the probe.
+func (a *Adapter) record(probe bool, failure error, ignored bool) {
+	a.mutex.Lock()
+	var events []cache.CacheEvent
+	if probe {
+		a.probing = false
+	}
+	// Only the probe decides a half-open circuit, and calls that were
+	// already running when the circuit opened do not count.
+	counted := !ignored && (a.state == StateClosed || probe && a.state == StateHalfOpen)
+	switch {
+	case !counted:
+	case failure == nil:
+		a.failures = 0
+		if a.state == StateHalfOpen {
+			a.state = StateClosed
+			events = append(events, cache.CacheEvent{Type: cache.CacheEventCircuit, Reason: cache.ReasonCircuitClosed})
+		}
+	default:
+		a.failures++
+		if a.state == StateHalfOpen || a.failures >= a.threshold {
+			a.state = StateOpen
+			a.openedAt = time.Now()
+			a.failures = 0
+			events = append(events, cache.CacheEvent{Type: cache.CacheEventCircuit, Reason: cache.ReasonCircuitOpen, Err: failure})
+		}
+	}
+	notify := a.notify
+	a.mutex.Unlock()
+
+	emit(notify, events)
+}
+
+// emit delivers events outside the lock so observers may use the
+// adapter.
+func emit(notify []func(cache.CacheEvent), events []cache.CacheEvent) {
+	for _, event := range events {
+		for _, fn := range notify {
+			fn(event)
+		}
+	}
+}
+
+// NewAdapter wraps adapter with a circuit breaker. Adapters implementing
+// cache.ContextAdapter report their failures through it; others can only
+// fail by timing out. Without options, calls time out after 250ms and the
+// circuit opens after 5 consecutive failures, for 10 seconds.
+func NewAdapter(adapter cache.Adapter, opts ...AdapterOptions) (cache.Adapter, error) {
+	if adapter == nil {
+		return nil, errors.New("breaker adapter requires an adapter to wrap")
+	}
+	a := &Adapter{
+		adapter:      adapter,
+		next:         cache.ToContextAdapter(adapter),
+		timeout:      defaultTimeout,
+		threshold:    defaultFailureThreshold,
+		cooldown:     defaultCooldown,
+		maxAbandoned: defaultMaxAbandoned,
+	}
+
+	for _, opt := range opts {
+		if err := opt(a); err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+// AdapterWithTimeout sets the deadline of each backend call. Calls
+// exceeding it fail with context.DeadlineExceeded and count as failures.
+func AdapterWithTimeout(timeout time.Duration) AdapterOptions {
+	return func(a *Adapter) error {
+		if timeout <= 0 {
+			return errors.New("breaker adapter requires a timeout greater than 0")
+		}
+
+		a.timeout = timeout
+
+		return nil
+	}
+}
+
+// AdapterWithLatencyThreshold counts calls that succeed but take longer
+// than threshold as failures, so a degrading backend opens the circuit
+// before it starts timing out. Zero, the default, disables the check.
+func AdapterWithLatencyThreshold(threshold time.Duration) AdapterOptions {
+	return func(a *Adapter) error {
+		if threshold < 0 {
+			return errors.New("breaker adapter requires a non-negative latency threshold")
+		}
+
+		a.slow = threshold
+
+		return nil
+	}
+}
+
+// AdapterWithFailureThreshold sets the number of consecutive failures
+// that open the circuit.
+func AdapterWithFailureThreshold(failures int) AdapterOptions {
+	return func(a *Adapter) error {
+		if failures <= 0 {
+			return fmt.Errorf("breaker adapter requires a failure threshold greater than %v", failures)
+		}
+
+		a.threshold = failures
+
+		return nil
+	}
+}
+
+// AdapterWithCooldown sets how long the circuit stays open before a
+// probe call is let through.
+func AdapterWithCooldown(cooldown time.Duration) AdapterOptions {
+	return func(a *Adapter) error {
+		if cooldown <= 0 {
+			return errors.New("breaker adapter requires a cooldown greater than 0")
+		}
+
+		a.cooldown = cooldown
+
+		return nil
+	}
+}
+
+// AdapterWithDirectCalls calls the wrapped adapter from the caller's
+// goroutine instead of a goroutine of its own. Only set it for adapters
+// that return as soon as their context is done, such as the memory
+// adapter: the timeout cannot interrupt the others. go-redis v6, used by
+// the redis adapter, does not.
+func AdapterWithDirectCalls() AdapterOptions {
+	return func(a *Adapter) error {
+		a.direct = true
+
+		return nil
+	}
+}
+
+// AdapterWithMaxAbandonedCalls sets how many calls may still be running
+// after their timeout before new calls fail right away. The default is
+// 64.
+func AdapterWithMaxAbandonedCalls(calls int) AdapterOptions {
+	return func(a *Adapter) error {
+		if calls <= 0 {
+			return errors.New("breaker adapter requires a maximum of abandoned calls greater than 0")
+		}
+
+		a.maxAbandoned = int64(calls)
+
+		return nil
+	}
+}
@@ -0,0 +1,432 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cache "github.com/victorspringer/http-cache"
+	"github.com/victorspringer/http-cache/adapter/memory"
+)
+
+var errDown = errors.New("connection refused")
+
+// backend is a remote store that can fail or slow down. Its context
+// methods give up when the context is done; legacy wraps it for an
+// adapter that cannot be interrupted.
+type backend struct {
+	mutex sync.Mutex
+	err   error
+	delay time.Duration
+	store map[uint64][]byte
+}
+
+func (b *backend) fail(err error, delay time.Duration) {
+	b.mutex.Lock()
+	b.err, b.delay = err, delay
+	b.mutex.Unlock()
+}
+
+func (b *backend) wait(ctx context.Context) error {
+	b.mutex.Lock()
+	err, delay := b.err, b.delay
+	b.mutex.Unlock()
+	select {
+	case <-time.After(delay):
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *backend) Get(key uint64) ([]byte, bool) {
+	v, ok, _ := b.GetContext(context.Background(), key)
+	return v, ok
+}
+
+func (b *backend) Set(key uint64, response []byte, expiration time.Time) {
+	b.SetContext(context.Background(), key, response, expiration)
+}
+
+func (b *backend) Release(key uint64) {
+	b.ReleaseContext(context.Background(), key)
+}
+
+func (b *backend) GetContext(ctx context.Context, key uint64) ([]byte, bool, error) {
+	if err := b.wait(ctx); err != nil {
+		return nil, false, err
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	v, ok := b.store[key]
+	return v, ok, nil
+}
+
+func (b *backend) SetContext(ctx context.Context, key uint64, response []byte, expiration time.Time) error {
+	if err := b.wait(ctx); err != nil {
+		return err
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.store[key] = response
+	return nil
+}
+
+func (b *backend) ReleaseContext(ctx context.Context, key uint64) error {
+	if err := b.wait(ctx); err != nil {
+		return err
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.store, key)
+	return nil
+}
+
+// legacy is a backend reached through the uint64 Adapter methods only,
+// which ignore the context.
+type legacy struct {
+	b *backend
+}
+
+func (l legacy) Get(key uint64) ([]byte, bool) {
+	return l.b.Get(key)
+}
+
+func (l legacy) Set(key uint64, response []byte, expiration time.Time) {
+	l.b.Set(key, response, expiration)
+}
+
+func (l legacy) Release(key uint64) {
+	l.b.Release(key)
+}
+
+// deaf is a cache.ContextAdapter whose context methods ignore the
+// context, like go-redis v6.
+type deaf struct {
+	legacy
+}
+
+func (d deaf) GetContext(ctx context.Context, key uint64) ([]byte, bool, error) {
+	b, ok := d.Get(key)
+	return b, ok, nil
+}
+
+func (d deaf) SetContext(ctx context.Context, key uint64, response []byte, expiration time.Time) error {
+	d.Set(key, response, expiration)
+	return nil
+}
+
+func (d deaf) ReleaseContext(ctx context.Context, key uint64) error {
+	d.Release(key)
+	return nil
+}
+
+type recorder struct {
+	mutex  sync.Mutex
+	events []cache.CacheEvent
+}
+
+func (r *recorder) observe(e cache.CacheEvent) {
+	r.mutex.Lock()
+	r.events = append(r.events, e)
+	r.mutex.Unlock()
+}
+
+func (r *recorder) reasons() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var reasons []string
+	for _, e := range r.events {
+		reasons = append(reasons, e.Reason)
+	}
+	return reasons
+}
+
+func newBreaker(t *testing.T, b cache.Adapter, opts ...AdapterOptions) (*Adapter, *recorder) {
+	t.Helper()
+	a, err := NewAdapter(b, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &recorder{}
+	a.(*Adapter).Notify(r.observe)
+	return a.(*Adapter), r
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCircuitOpensAndRecovers(t *testing.T) {
+	b := &backend{store: map[uint64][]byte{1: []byte("v")}}
+	a, r := newBreaker(t, b, AdapterWithFailureThreshold(3), AdapterWithCooldown(30*time.Millisecond))
+	ctx := context.Background()
+
+	b.fail(errDown, 0)
+	for i := 0; i < 3; i++ {
+		if _, _, err := a.GetContext(ctx, 1); !errors.Is(err, errDown) {
+			t.Fatalf("GetContext() error = %v, want the backend error", err)
+		}
+	}
+	if a.State() != StateOpen {
+		t.Fatalf("State() = %v after 3 failures, want open", a.State())
+	}
+	_, _, err := a.GetContext(ctx, 1)
+	if !errors.Is(err, ErrOpen) || !errors.Is(err, cache.ErrAdapterUnavailable) {
+		t.Fatalf("GetContext() on an open circuit error = %v, want ErrOpen", err)
+	}
+	if r.events[0].Type != cache.CacheEventCircuit || !errors.Is(r.events[0].Err, errDown) {
+		t.Errorf("open event = %+v, want a circuit event carrying the backend error", r.events[0])
+	}
+
+	b.fail(nil, 0)
+	time.Sleep(40 * time.Millisecond)
+	if v, ok, err := a.GetContext(ctx, 1); err != nil || !ok || string(v) != "v" {
+		t.Fatalf("probe GetContext() = %q, %v, %v; want v", v, ok, err)
+	}
+	if a.State() != StateClosed {
+		t.Fatalf("State() = %v after a successful probe, want closed", a.State())
+	}
+	want := []string{cache.ReasonCircuitOpen, cache.ReasonCircuitHalfOpen, cache.ReasonCircuitClosed}
+	if got := r.reasons(); !equal(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
+
+func TestFailedProbeReopensCircuit(t *testing.T) {
+	b := &backend{store: map[uint64][]byte{}}
+	a, r := newBreaker(t, b, AdapterWithFailureThreshold(1), AdapterWithCooldown(20*time.Millisecond))
+	ctx := context.Background()
+
+	b.fail(errDown, 0)
+	a.SetContext(ctx, 1, []byte("v"), time.Time{})
+	time.Sleep(30 * time.Millisecond)
+	if err := a.ReleaseContext(ctx, 1); !errors.Is(err, errDown) {
+		t.Fatalf("probe error = %v, want the backend error", err)
+	}
+	if err := a.ReleaseContext(ctx, 1); !errors.Is(err, ErrOpen) {
+		t.Fatalf("error after a failed probe = %v, want ErrOpen", err)
+	}
+	want := []string{cache.ReasonCircuitOpen, cache.ReasonCircuitHalfOpen, cache.ReasonCircuitOpen}
+	if got := r.reasons(); !equal(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
+
+// Only one call probes a half-open circuit; the others are refused
+// until it completes.
+func TestHalfOpenLetsOneProbeThrough(t *testing.T) {
+	b := &backend{store: map[uint64][]byte{}}
+	a, _ := newBreaker(t, b, AdapterWithFailureThreshold(1), AdapterWithCooldown(10*time.Millisecond), AdapterWithTimeout(time.Second))
+	ctx := context.Background()
+
+	b.fail(errDown, 0)
+	a.GetContext(ctx, 1)
+	time.Sleep(20 * time.Millisecond)
+
+	b.fail(nil, 50*time.Millisecond)
+	probed := make(chan error)
+	go func() {
+		_, _, err := a.GetContext(ctx, 1)
+		probed <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if _, _, err := a.GetContext(ctx, 1); !errors.Is(err, ErrOpen) {
+		t.Errorf("GetContext() during the probe error = %v, want ErrOpen", err)
+	}
+	if err := <-probed; err != nil {
+		t.Fatalf("probe error = %v", err)
+	}
+	if a.State() != StateClosed {
+		t.Errorf("State() = %v, want closed", a.State())
+	}
+}
+
+// A backend must not hold the caller past the timeout, whether it honors
+// the context or not.
+func TestTimeoutBoundsSlowBackend(t *testing.T) {
+	for name, tt := range map[string]struct {
+		adapter func(*backend) cache.Adapter
+		opts    []AdapterOptions
+	}{
+		"context":         {adapter: func(b *backend) cache.Adapter { return b }},
+		"ignores context": {adapter: func(b *backend) cache.Adapter { return deaf{legacy{b}} }},
+		"legacy":          {adapter: func(b *backend) cache.Adapter { return legacy{b} }},
+		"direct":          {adapter: func(b *backend) cache.Adapter { return b }, opts: []AdapterOptions{AdapterWithDirectCalls()}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			b := &backend{store: map[uint64][]byte{}}
+			opts := append([]AdapterOptions{AdapterWithTimeout(10 * time.Millisecond), AdapterWithFailureThreshold(1)}, tt.opts...)
+			a, r := newBreaker(t, tt.adapter(b), opts...)
+			testTimeoutBoundsSlowBackend(t, a, b, r)
+		})
+	}
+}
+
+func testTimeoutBoundsSlowBackend(t *testing.T, a *Adapter, b *backend, r *recorder) {
+
+	b.fail(nil, 200*time.Millisecond)
+	start := time.Now()
+	_, _, err := a.GetContext(context.Background(), 1)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("GetContext() error = %v, want context.DeadlineExceeded", err)
+	}
+	if took := time.Since(start); took > 100*time.Millisecond {
+		t.Errorf("GetContext() took %v, want about the 10ms timeout", took)
+	}
+	if got := r.reasons(); !equal(got, []string{cache.ReasonCircuitOpen}) {
+		t.Errorf("events = %v, want the circuit to open", got)
+	}
+}
+
+func TestLatencyThresholdOpensCircuit(t *testing.T) {
+	b := &backend{store: map[uint64][]byte{1: []byte("v")}}
+	a, _ := newBreaker(t, b, AdapterWithLatencyThreshold(time.Millisecond), AdapterWithFailureThreshold(2))
+
+	b.fail(nil, 5*time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if v, ok, err := a.GetContext(context.Background(), 1); err != nil || !ok || string(v) != "v" {
+			t.Fatalf("slow GetContext() = %q, %v, %v; want the value", v, ok, err)
+		}
+	}
+	if a.State() != StateOpen {
+		t.Errorf("State() = %v after 2 slow calls, want open", a.State())
+	}
+}
+
+// A caller giving up says nothing about the backend.
+func TestCanceledCallsDoNotCount(t *testing.T) {
+	b := &backend{store: map[uint64][]byte{}}
+	a, _ := newBreaker(t, b, AdapterWithFailureThreshold(1))
+
+	b.fail(nil, 50*time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	if _, _, err := a.GetContext(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("GetContext() error = %v, want context.DeadlineExceeded", err)
+	}
+	if a.State() != StateClosed {
+		t.Errorf("State() = %v, want closed", a.State())
+	}
+}
+
+func TestNewAdapterRejectsInvalidValues(t *testing.T) {
+	if _, err := NewAdapter(nil); err == nil {
+		t.Error("NewAdapter(nil) error = nil, want error")
+	}
+	for _, opt := range []AdapterOptions{
+		AdapterWithTimeout(0),
+		AdapterWithLatencyThreshold(-time.Second),
+		AdapterWithFailureThreshold(0),
+		AdapterWithCooldown(0),
+		AdapterWithMaxAbandonedCalls(0),
+	} {
+		if _, err := NewAdapter(&backend{}, opt); err == nil {
+			t.Error("NewAdapter() error = nil, want error")
+		}
+	}
+}
+
+// Calls to a stalled legacy backend are abandoned at the timeout; past
+// the limit, new calls fail without starting more.
+func TestAbandonedCallsAreBounded(t *testing.T) {
+	b := &backend{store: map[uint64][]byte{}}
+	a, _ := newBreaker(t, legacy{b},
+		AdapterWithTimeout(5*time.Millisecond),
+		AdapterWithFailureThreshold(10),
+		AdapterWithMaxAbandonedCalls(2),
+	)
+
+	b.fail(nil, 100*time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if _, _, err := a.GetContext(context.Background(), 1); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("GetContext() error = %v, want context.DeadlineExceeded", err)
+		}
+	}
+	if _, _, err := a.GetContext(context.Background(), 1); !errors.Is(err, errAbandoned) {
+		t.Fatalf("GetContext() past the limit error = %v, want errAbandoned", err)
+	}
+
+	b.fail(nil, 0)
+	time.Sleep(150 * time.Millisecond)
+	if got := a.abandoned.Load(); got != 0 {
+		t.Fatalf("%d calls still abandoned after the backend answered", got)
+	}
+	if _, _, err := a.GetContext(context.Background(), 1); err != nil {
+		t.Errorf("GetContext() once the backend answered error = %v", err)
+	}
+}
+
+// countingMemory is a memory adapter counting the entries written.
+type countingMemory struct {
+	*memory.Adapter
+	sets atomic.Int32
+}
+
+func (m *countingMemory) SetWide(ctx context.Context, key string, response []byte, expiration time.Time) error {
+	m.sets.Add(1)
+	return m.Adapter.SetWide(ctx, key, response, expiration)
+}
+
+// The client reaches the optional interfaces of the wrapped adapter.
+func TestClientUsesWrappedAdapterInterfaces(t *testing.T) {
+	m, err := memory.NewAdapter(memory.AdapterWithAlgorithm(memory.LRU), memory.AdapterWithCapacity(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner := &countingMemory{Adapter: m.(*memory.Adapter)}
+	a, err := NewAdapter(inner, AdapterWithDirectCalls())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := cache.NewClient(cache.ClientWithAdapter(a), cache.ClientWithTTL(time.Minute), cache.ClientWithWideKeys())
+	if err != nil {
+		t.Fatalf("NewClient() with wide keys error = %v", err)
+	}
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	for i := 0; i < 2; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://x/", nil))
+	}
+	if got := inner.sets.Load(); got != 1 {
+		t.Errorf("%d entries written, want 1: hits must be recorded with TouchWide", got)
+	}
+	if usage := client.Stats().Adapter; usage == nil || usage.Entries != 1 {
+		t.Errorf("Stats().Adapter = %+v, want 1 entry", usage)
+	}
+
+	admin := client.AdminHandler()
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/keys", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"url":"http://x/"`) {
+		t.Errorf("GET /keys = %d %s, want the entry", w.Code, w.Body)
+	}
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/flush", nil))
+	if w.Code != http.StatusNoContent || len(inner.WideKeys()) != 0 {
+		t.Errorf("POST /flush = %d %s, %d entries left", w.Code, w.Body, len(inner.WideKeys()))
+	}
+
+	a, err = NewAdapter(legacy{&backend{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.NewClient(cache.ClientWithAdapter(a), cache.ClientWithTTL(time.Minute), cache.ClientWithWideKeys()); err == nil {
+		t.Error("NewClient() with wide keys over a uint64-only adapter error = nil, want error")
+	}
+}
@@ -0,0 +1,385 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package compress wraps a cache adapter so large responses are stored
+// compressed.
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+	cache "github.com/victorspringer/http-cache"
+)
+
+// Algorithm is a compression algorithm. Its value is the marker byte
+// prepended to the values it compressed.
+type Algorithm byte
+
+const (
+	// Gzip compresses with gzip at the default level.
+	Gzip Algorithm = 1
+
+	// Zstd compresses with Zstandard at the default level.
+	Zstd Algorithm = 2
+
+	// S2 compresses with S2, a faster Snappy extension.
+	S2 Algorithm = 3
+)
+
+// String returns the algorithm name.
+func (alg Algorithm) String() string {
+	switch alg {
+	case Gzip:
+		return "gzip"
+	case Zstd:
+		return "zstd"
+	case S2:
+		return "s2"
+	default:
+		return fmt.Sprintf("Algorithm(%d)", byte(alg))
+	}
+}
+
+// markerRaw prefixes values stored uncompressed whose first byte is a
+// marker, so they are not mistaken for compressed ones. Other values
+// stored uncompressed are left as they are.
+const markerRaw = 0
+
+const (
+	defaultThreshold      = 1024
+	defaultMaxDecodedSize = 64 << 20
+)
+
+var (
+	errTooLarge   = errors.New("compress: decoded value is too large")
+	errNoWideKeys = errors.New("compress: wrapped adapter does not take wide keys")
+)
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	gzipWriters    = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+)
+
+// Stats reports how much the adapter saved.
+type Stats struct {
+	// Compressed is the number of values stored compressed.
+	Compressed uint64
+
+	// Skipped is the number of values stored uncompressed, because they
+	// were below the threshold or did not shrink.
+	Skipped uint64
+
+	// BytesIn and BytesOut are the sizes of the compressed values before
+	// and after compression.
+	BytesIn  uint64
+	BytesOut uint64
+}
+
+// Ratio returns BytesIn divided by BytesOut, the compression ratio of
+// the compressed values, or 0 before any value was compressed.
+func (s Stats) Ratio() float64 {
+	if s.BytesOut == 0 {
+		return 0
+	}
+	return float64(s.BytesIn) / float64(s.BytesOut)
+}
+
+// Adapter is the compression adapter data structure.
+type Adapter struct {
+	adapter   cache.Adapter
+	next      cache.ContextAdapter
+	algorithm Algorithm
+	threshold int
+	maxSize   int
+	zstd      *zstd.Decoder
+
+	compressed atomic.Uint64
+	skipped    atomic.Uint64
+	bytesIn    atomic.Uint64
+	bytesOut   atomic.Uint64
+}
+
+// AdapterOptions is used to set Adapter settings.
+type AdapterOptions func(a *Adapter) error
+
+// Get implements the cache Adapter interface Get method.
+func (a *Adapter) Get(key uint64) ([]byte, bool) {
+	b, ok, _ := a.GetContext(context.Background(), key)
+	return b, ok
+}
+
+// Set implements the cache Adapter interface Set method.
+func (a *Adapter) Set(key uint64, response []byte, expiration time.Time) {
+	a.SetContext(context.Background(), key, response, expiration)
+}
+
+// Release implements the cache Adapter interface Release method.
+func (a *Adapter) Release(key uint64) {
+	a.ReleaseContext(context.Background(), key)
+}
+
+// GetContext implements the cache.ContextAdapter interface. Values are
+// decompressed with the algorithm they were stored with, whatever the
+// configured one; values that fail to decompress, or would decompress
+// to more than the size set by AdapterWithMaxDecodedSize, are misses.
+func (a *Adapter) GetContext(ctx context.Context, key uint64) ([]byte, bool, error) {
+	b, ok, err := a.next.GetContext(ctx, key)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	value, err := a.decompress(b)
+	if err != nil {
+		return nil, false, nil
+	}
+	return value, true, nil
+}
+
+// SetContext implements the cache.ContextAdapter interface.
+func (a *Adapter) SetContext(ctx context.Context, key uint64, response []byte, expiration time.Time) error {
+	return a.next.SetContext(ctx, key, a.compress(response), expiration)
+}
+
+// ReleaseContext implements the cache.ContextAdapter interface.
+func (a *Adapter) ReleaseContext(ctx context.Context, key uint64) error {
+	return a.next.ReleaseContext(ctx, key)
+}
+
+// GetWide implements the cache.WideKeyAdapter interface for a wrapped
+// adapter implementing it, decompressing like GetContext.
+func (a *Adapter) GetWide(ctx context.Context, key string) ([]byte, bool, error) {
+	wa, ok := a.adapter.(cache.WideKeyAdapter)
+	if !ok {
+		return nil, false, errNoWideKeys
+	}
+	b, ok, err := wa.GetWide(ctx, key)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	value, err := a.decompress(b)
+	if err != nil {
+		return nil, false, nil
+	}
+	return value, true, nil
+}
+
+// SetWide implements the cache.WideKeyAdapter interface.
+func (a *Adapter) SetWide(ctx context.Context, key string, response []byte, expiration time.Time) error {
+	wa, ok := a.adapter.(cache.WideKeyAdapter)
+	if !ok {
+		return errNoWideKeys
+	}
+	return wa.SetWide(ctx, key, a.compress(response), expiration)
+}
+
+// ReleaseWide implements the cache.WideKeyAdapter interface.
+func (a *Adapter) ReleaseWide(ctx context.Context, key string) error {
+	wa, ok := a.adapter.(cache.WideKeyAdapter)
+	if !ok {
+		return errNoWideKeys
+	}
+	return wa.ReleaseWide(ctx, key)
+}
+
+// Unwrap implements the cache.AdapterWrapper optional interface, so the
+// client reaches the optional interfaces of the wrapped adapter.
+func (a *Adapter) Unwrap() cache.Adapter {
+	return a.adapter
+}
+
+// Notify implements the cache.AdapterNotifier optional interface by
+// forwarding fn to the wrapped adapter, when it is a notifier.
+func (a *Adapter) Notify(fn func(cache.CacheEvent)) {
+	if n, ok := a.adapter.(cache.AdapterNotifier); ok {
+		n.Notify(fn)
+	}
+}
+
+// Stats returns the compression counters since the adapter was created.
+func (a *Adapter) Stats() Stats {
+	return Stats{
+		Compressed: a.compressed.Load(),
+		Skipped:    a.skipped.Load(),
+		BytesIn:    a.bytesIn.Load(),
+		BytesOut:   a.bytesOut.Load(),
+	}
+}
+
+// compress returns the value to store for response.
+func (a *Adapter) compress(response []byte) []byte {
+	if len(response) >= a.threshold {
+		if b := encode(a.algorithm, response); len(b) < len(response) {
+			a.compressed.Add(1)
+			a.bytesIn.Add(uint64(len(response)))
+			a.bytesOut.Add(uint64(len(b)))
+			return b
+		}
+	}
+	a.skipped.Add(1)
+	if len(response) > 0 && response[0] <= byte(S2) {
+		return append([]byte{markerRaw}, response...)
+	}
+	return response
+}
+
+// encode compresses value with alg, after its marker byte.
+func encode(alg Algorithm, value []byte) []byte {
+	dst := make([]byte, 1, len(value)/2+1)
+	dst[0] = byte(alg)
+	switch alg {
+	case Zstd:
+		return zstdEncoder.EncodeAll(value, dst)
+	case S2:
+		return append(dst, s2.Encode(nil, value)...)
+	default:
+		buf := bytes.NewBuffer(dst)
+		w := gzipWriters.Get().(*gzip.Writer)
+		w.Reset(buf)
+		w.Write(value)
+		w.Close()
+		gzipWriters.Put(w)
+		return buf.Bytes()
+	}
+}
+
+// decompress returns the value stored as b, or errTooLarge when it
+// decompresses to more than a.maxSize bytes.
+func (a *Adapter) decompress(b []byte) ([]byte, error) {
+	if len(b) == 0 || b[0] > byte(S2) {
+		return b, nil
+	}
+	switch Algorithm(b[0]) {
+	case Gzip:
+		r, err := gzip.NewReader(bytes.NewReader(b[1:]))
+		if err != nil {
+			return nil, err
+		}
+		value, err := io.ReadAll(io.LimitReader(r, int64(a.maxSize)+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(value) > a.maxSize {
+			return nil, errTooLarge
+		}
+		return value, nil
+	case Zstd:
+		return a.zstd.DecodeAll(b[1:], nil)
+	case S2:
+		n, err := s2.DecodedLen(b[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n > a.maxSize {
+			return nil, errTooLarge
+		}
+		return s2.Decode(nil, b[1:])
+	default:
+		return b[1:], nil
+	}
+}
+
+// NewAdapter wraps adapter so values of at least 1KB are compressed
+// with the algorithm set by AdapterWithAlgorithm, when that makes them
+// smaller.
+func NewAdapter(adapter cache.Adapter, opts ...AdapterOptions) (*Adapter, error) {
+	if adapter == nil {
+		return nil, errors.New("compress adapter requires an adapter to wrap")
+	}
+	a := &Adapter{
+		adapter:   adapter,
+		next:      cache.ToContextAdapter(adapter),
+		threshold: defaultThreshold,
+		maxSize:   defaultMaxDecodedSize,
+	}
+
+	for _, opt := range opts {
+		if err := opt(a); err != nil {
+			return nil, err
+		}
+	}
+
+	if a.algorithm == 0 {
+		return nil, errors.New("compress adapter algorithm is not set")
+	}
+
+	d, err := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(a.maxSize)))
+	if err != nil {
+		return nil, err
+	}
+	a.zstd = d
+
+	return a, nil
+}
+
+// AdapterWithAlgorithm sets the algorithm new values are compressed
+// with.
+func AdapterWithAlgorithm(alg Algorithm) AdapterOptions {
+	return func(a *Adapter) error {
+		if alg != Gzip && alg != Zstd && alg != S2 {
+			return fmt.Errorf("compress adapter algorithm %v is not supported", alg)
+		}
+
+		a.algorithm = alg
+
+		return nil
+	}
+}
+
+// AdapterWithThreshold sets the size in bytes from which values are
+// compressed.
+func AdapterWithThreshold(threshold int) AdapterOptions {
+	return func(a *Adapter) error {
+		if threshold < 0 {
+			return errors.New("compress adapter requires a non-negative threshold")
+		}
+
+		a.threshold = threshold
+
+		return nil
+	}
+}
+
+// AdapterWithMaxDecodedSize sets the size in bytes a stored value may
+// decompress to, 64MB by default. Larger values are misses, so an entry
+// planted in a shared backend cannot exhaust memory.
+func AdapterWithMaxDecodedSize(size int) AdapterOptions {
+	return func(a *Adapter) error {
+		if size <= 0 {
+			return errors.New("compress adapter requires a positive max decoded size")
+		}
+
+		a.maxSize = size
+
+		return nil
+	}
+}
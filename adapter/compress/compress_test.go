@@ -0,0 +1,218 @@
+package compress
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cache "github.com/victorspringer/http-cache"
+	"github.com/victorspringer/http-cache/adapter/memory"
+)
+
+var payload = bytes.Repeat([]byte(`{"id":1,"name":"product","price":9.99},`), 100)
+
+func newMemory(t *testing.T) cache.Adapter {
+	t.Helper()
+	m, err := memory.NewAdapter(memory.AdapterWithAlgorithm(memory.LRU), memory.AdapterWithCapacity(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestCompressesLargeValues(t *testing.T) {
+	for _, alg := range []Algorithm{Gzip, Zstd, S2} {
+		t.Run(alg.String(), func(t *testing.T) {
+			backend := newMemory(t)
+			a, err := NewAdapter(backend, AdapterWithAlgorithm(alg))
+			if err != nil {
+				t.Fatal(err)
+			}
+			a.Set(1, payload, time.Time{})
+
+			stored, _ := backend.Get(1)
+			if stored[0] != byte(alg) || len(stored) >= len(payload)/4 {
+				t.Errorf("stored %d bytes with marker %d, want a %v value under %d bytes", len(stored), stored[0], alg, len(payload)/4)
+			}
+			if got, ok := a.Get(1); !ok || !bytes.Equal(got, payload) {
+				t.Fatalf("Get() = %d bytes, %v; want the payload", len(got), ok)
+			}
+
+			stats := a.Stats()
+			if stats.Compressed != 1 || stats.BytesIn != uint64(len(payload)) || stats.BytesOut != uint64(len(stored)) || stats.Ratio() < 4 {
+				t.Errorf("Stats() = %+v (ratio %.1f), want one compressed value", stats, stats.Ratio())
+			}
+		})
+	}
+}
+
+func TestStoresSmallAndIncompressibleValuesAsIs(t *testing.T) {
+	backend := newMemory(t)
+	a, err := NewAdapter(backend, AdapterWithAlgorithm(Zstd), AdapterWithThreshold(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.Set(1, []byte("HC small"), time.Time{})
+	if stored, _ := backend.Get(1); string(stored) != "HC small" {
+		t.Errorf("stored %q, want the value as is", stored)
+	}
+
+	// A value starting like a marker must be escaped.
+	marked := append([]byte{byte(Gzip)}, bytes.Repeat([]byte{0xAB, 0x17, 0x42}, 2)...)
+	a.Set(2, marked, time.Time{})
+	if got, ok := a.Get(2); !ok || !bytes.Equal(got, marked) {
+		t.Errorf("Get() = %v, %v; want %v", got, ok, marked)
+	}
+	if stats := a.Stats(); stats.Skipped != 2 || stats.Compressed != 0 || stats.Ratio() != 0 {
+		t.Errorf("Stats() = %+v, want 2 skipped values", stats)
+	}
+}
+
+// Values written with another algorithm, or before compression was
+// enabled, must still be read.
+func TestReadsMixedValues(t *testing.T) {
+	backend := newMemory(t)
+	backend.Set(1, []byte("HC written without compression"), time.Time{})
+	gz, err := NewAdapter(backend, AdapterWithAlgorithm(Gzip))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz.Set(2, payload, time.Time{})
+
+	s, err := NewAdapter(backend, AdapterWithAlgorithm(S2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := s.Get(1); !ok || string(got) != "HC written without compression" {
+		t.Errorf("Get() of an uncompressed value = %q, %v", got, ok)
+	}
+	if got, ok := s.Get(2); !ok || !bytes.Equal(got, payload) {
+		t.Errorf("Get() of a gzip value = %d bytes, %v; want the payload", len(got), ok)
+	}
+
+	backend.Set(3, []byte{byte(Zstd), 1, 2, 3}, time.Time{})
+	if _, ok := s.Get(3); ok {
+		t.Error("Get() of a corrupt value found it, want a miss")
+	}
+}
+
+func TestOversizeValuesAreMisses(t *testing.T) {
+	for _, alg := range []Algorithm{Gzip, Zstd, S2} {
+		t.Run(alg.String(), func(t *testing.T) {
+			backend := newMemory(t)
+			w, err := NewAdapter(backend, AdapterWithAlgorithm(alg))
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Set(1, payload, time.Time{})
+
+			r, err := NewAdapter(backend, AdapterWithAlgorithm(alg), AdapterWithMaxDecodedSize(len(payload)-1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := r.Get(1); ok {
+				t.Error("Get() of a value over the max decoded size found it, want a miss")
+			}
+
+			r, err = NewAdapter(backend, AdapterWithAlgorithm(alg), AdapterWithMaxDecodedSize(len(payload)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, ok := r.Get(1); !ok || !bytes.Equal(got, payload) {
+				t.Errorf("Get() of a value at the max decoded size = %d bytes, %v; want the payload", len(got), ok)
+			}
+		})
+	}
+}
+
+func TestMiddlewareWithCompressedAdapter(t *testing.T) {
+	a, err := NewAdapter(newMemory(t), AdapterWithAlgorithm(Zstd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := cache.NewClient(cache.ClientWithAdapter(a), cache.ClientWithTTL(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write(payload)
+	}))
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://x/large", nil))
+		if !bytes.Equal(w.Body.Bytes(), payload) {
+			t.Fatalf("body = %d bytes, want the payload", w.Body.Len())
+		}
+	}
+	if calls != 1 {
+		t.Errorf("origin called %d times, want 1", calls)
+	}
+}
+
+func TestNewAdapterRejectsInvalidValues(t *testing.T) {
+	m := newMemory(t)
+	for _, opts := range [][]AdapterOptions{
+		nil,
+		{AdapterWithAlgorithm(9)},
+		{AdapterWithAlgorithm(Gzip), AdapterWithThreshold(-1)},
+		{AdapterWithAlgorithm(Gzip), AdapterWithMaxDecodedSize(0)},
+	} {
+		if _, err := NewAdapter(m, opts...); err == nil {
+			t.Errorf("NewAdapter() with %d options error = nil, want error", len(opts))
+		}
+	}
+	if _, err := NewAdapter(nil, AdapterWithAlgorithm(Gzip)); err == nil {
+		t.Error("NewAdapter(nil) error = nil, want error")
+	}
+}
+
+// countingMemory is a memory adapter counting the entries written.
+type countingMemory struct {
+	*memory.Adapter
+	sets atomic.Int32
+}
+
+func (m *countingMemory) SetWide(ctx context.Context, key string, response []byte, expiration time.Time) error {
+	m.sets.Add(1)
+	return m.Adapter.SetWide(ctx, key, response, expiration)
+}
+
+// Hits are recorded through the wrapped adapter, without compressing
+// and writing the entry again, and wide keys reach it compressed.
+func TestClientUsesWrappedAdapterInterfaces(t *testing.T) {
+	inner := &countingMemory{Adapter: newMemory(t).(*memory.Adapter)}
+	a, err := NewAdapter(inner, AdapterWithAlgorithm(Zstd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := cache.NewClient(cache.ClientWithAdapter(a), cache.ClientWithTTL(time.Minute), cache.ClientWithWideKeys())
+	if err != nil {
+		t.Fatalf("NewClient() with wide keys error = %v", err)
+	}
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(payload)
+	}))
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://x/large", nil))
+		if !bytes.Equal(w.Body.Bytes(), payload) {
+			t.Fatalf("body = %d bytes, want the payload", w.Body.Len())
+		}
+	}
+	if got := inner.sets.Load(); got != 1 {
+		t.Errorf("%d entries written, want 1: hits must be recorded with TouchWide", got)
+	}
+	if usage := client.Stats().Adapter; usage == nil || usage.Entries != 1 || usage.Bytes >= int64(len(payload)) {
+		t.Errorf("Stats().Adapter = %+v, want 1 compressed entry", usage)
+	}
+	if stats := a.Stats(); stats.Compressed != 1 {
+		t.Errorf("Stats() = %+v, want 1 compressed value", stats)
+	}
+}
@@ -0,0 +1,10 @@
+module github.com/victorspringer/http-cache/adapter/compress
+
+go 1.23.0
+
+require (
+	github.com/klauspost/compress v1.18.0
+	github.com/victorspringer/http-cache v0.0.0-00010101000000-000000000000
+)
+
+replace github.com/victorspringer/http-cache => ../..
@@ -0,0 +1,278 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package encrypt wraps a cache adapter so responses are stored
+// encrypted with AES-GCM, for backends shared with other services or
+// holding personal data.
+package encrypt
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	cache "github.com/victorspringer/http-cache"
+)
+
+// Each stored value is an envelope: the magic byte 'E', the envelope
+// version, the uint32 big-endian ID of the key, the nonce, then the
+// ciphertext with its tag. The envelope header and the cache key, its 8
+// big-endian bytes or the wide key, are authenticated as additional
+// data, so an entry copied under another key fails to decrypt.
+const (
+	envelopeMagic   = 'E'
+	envelopeVersion = 1
+	headerSize      = 1 + 1 + 4
+)
+
+// errNoWideKeys is returned by the wide key methods when the wrapped
+// adapter does not implement cache.WideKeyAdapter.
+var errNoWideKeys = errors.New("encrypt: wrapped adapter does not take wide keys")
+
+// Adapter is the encryption adapter data structure.
+type Adapter struct {
+	adapter cache.Adapter
+	next    cache.ContextAdapter
+	keyID   uint32
+	aead    cipher.AEAD
+	keys    map[uint32]cipher.AEAD
+}
+
+// AdapterOptions is used to set Adapter settings.
+type AdapterOptions func(a *Adapter) error
+
+// Get implements the cache Adapter interface Get method.
+func (a *Adapter) Get(key uint64) ([]byte, bool) {
+	b, ok, _ := a.GetContext(context.Background(), key)
+	return b, ok
+}
+
+// Set implements the cache Adapter interface Set method.
+func (a *Adapter) Set(key uint64, response []byte, expiration time.Time) {
+	a.SetContext(context.Background(), key, response, expiration)
+}
+
+// Release implements the cache Adapter interface Release method.
+func (a *Adapter) Release(key uint64) {
+	a.ReleaseContext(context.Background(), key)
+}
+
+// GetContext implements the cache.ContextAdapter interface. Entries that
+// fail to decrypt, because they were tampered with, written under
+// another key ID or are not encrypted, are reported as misses so the
+// middleware replaces them.
+func (a *Adapter) GetContext(ctx context.Context, key uint64) ([]byte, bool, error) {
+	b, ok, err := a.next.GetContext(ctx, key)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	plain, err := a.open(binary.BigEndian.AppendUint64(nil, key), b)
+	if err != nil {
+		return nil, false, nil
+	}
+	return plain, true, nil
+}
+
+// SetContext implements the cache.ContextAdapter interface.
+func (a *Adapter) SetContext(ctx context.Context, key uint64, response []byte, expiration time.Time) error {
+	b, err := a.seal(binary.BigEndian.AppendUint64(nil, key), response)
+	if err != nil {
+		return err
+	}
+	return a.next.SetContext(ctx, key, b, expiration)
+}
+
+// ReleaseContext implements the cache.ContextAdapter interface.
+func (a *Adapter) ReleaseContext(ctx context.Context, key uint64) error {
+	return a.next.ReleaseContext(ctx, key)
+}
+
+// GetWide implements the cache.WideKeyAdapter interface for a wrapped
+// adapter implementing it, decrypting like GetContext.
+func (a *Adapter) GetWide(ctx context.Context, key string) ([]byte, bool, error) {
+	wa, ok := a.adapter.(cache.WideKeyAdapter)
+	if !ok {
+		return nil, false, errNoWideKeys
+	}
+	b, ok, err := wa.GetWide(ctx, key)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	plain, err := a.open([]byte(key), b)
+	if err != nil {
+		return nil, false, nil
+	}
+	return plain, true, nil
+}
+
+// SetWide implements the cache.WideKeyAdapter interface.
+func (a *Adapter) SetWide(ctx context.Context, key string, response []byte, expiration time.Time) error {
+	wa, ok := a.adapter.(cache.WideKeyAdapter)
+	if !ok {
+		return errNoWideKeys
+	}
+	b, err := a.seal([]byte(key), response)
+	if err != nil {
+		return err
+	}
+	return wa.SetWide(ctx, key, b, expiration)
+}
+
+// ReleaseWide implements the cache.WideKeyAdapter interface.
+func (a *Adapter) ReleaseWide(ctx context.Context, key string) error {
+	wa, ok := a.adapter.(cache.WideKeyAdapter)
+	if !ok {
+		return errNoWideKeys
+	}
+	return wa.ReleaseWide(ctx, key)
+}
+
+// Unwrap implements the cache.AdapterWrapper optional interface, so the
+// client reaches the optional interfaces of the wrapped adapter.
+func (a *Adapter) Unwrap() cache.Adapter {
+	return a.adapter
+}
+
+// Notify implements the cache.AdapterNotifier optional interface by
+// forwarding fn to the wrapped adapter, when it is a notifier.
+func (a *Adapter) Notify(fn func(cache.CacheEvent)) {
+	if n, ok := a.adapter.(cache.AdapterNotifier); ok {
+		n.Notify(fn)
+	}
+}
+
+// seal encrypts response, stored under key, with the current key.
+func (a *Adapter) seal(key, response []byte) ([]byte, error) {
+	nonceSize := a.aead.NonceSize()
+	b := make([]byte, headerSize+nonceSize, headerSize+nonceSize+len(response)+a.aead.Overhead())
+	b[0] = envelopeMagic
+	b[1] = envelopeVersion
+	binary.BigEndian.PutUint32(b[2:], a.keyID)
+	nonce := b[headerSize:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return a.aead.Seal(b, nonce, response, additionalData(b[:headerSize], key)), nil
+}
+
+// open decrypts b, stored under key, with the key it was sealed with.
+func (a *Adapter) open(key, b []byte) ([]byte, error) {
+	if len(b) < headerSize || b[0] != envelopeMagic || b[1] != envelopeVersion {
+		return nil, errors.New("encrypt: not an encrypted entry")
+	}
+	aead, ok := a.keys[binary.BigEndian.Uint32(b[2:])]
+	if !ok {
+		return nil, errors.New("encrypt: unknown key ID")
+	}
+	nonceSize := aead.NonceSize()
+	if len(b) < headerSize+nonceSize {
+		return nil, errors.New("encrypt: truncated entry")
+	}
+	nonce, ciphertext := b[headerSize:headerSize+nonceSize], b[headerSize+nonceSize:]
+	return aead.Open(nil, nonce, ciphertext, additionalData(b[:headerSize], key))
+}
+
+func additionalData(header, key []byte) []byte {
+	ad := make([]byte, 0, len(header)+len(key))
+	ad = append(ad, header...)
+	return append(ad, key...)
+}
+
+// NewAdapter wraps adapter so values are encrypted with the key set by
+// AdapterWithKey.
+func NewAdapter(adapter cache.Adapter, opts ...AdapterOptions) (cache.Adapter, error) {
+	if adapter == nil {
+		return nil, errors.New("encrypt adapter requires an adapter to wrap")
+	}
+	a := &Adapter{
+		adapter: adapter,
+		next:    cache.ToContextAdapter(adapter),
+		keys:    make(map[uint32]cipher.AEAD),
+	}
+
+	for _, opt := range opts {
+		if err := opt(a); err != nil {
+			return nil, err
+		}
+	}
+
+	if a.aead == nil {
+		return nil, errors.New("encrypt adapter key is not set")
+	}
+
+	return a, nil
+}
+
+// AdapterWithKey sets the AES key, of 16, 24 or 32 bytes, used to
+// encrypt new entries, identified by id in their envelope. It also
+// decrypts entries written with id.
+func AdapterWithKey(id uint32, key []byte) AdapterOptions {
+	return func(a *Adapter) error {
+		aead, err := newAEAD(id, key)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := a.keys[id]; ok {
+			return fmt.Errorf("encrypt adapter key ID %d is set twice", id)
+		}
+		a.keyID = id
+		a.aead = aead
+		a.keys[id] = aead
+
+		return nil
+	}
+}
+
+// AdapterWithDecryptionKey adds a key accepted for entries written with
+// id, e.g. the previous key during a rotation. Entries under any other
+// ID are misses.
+func AdapterWithDecryptionKey(id uint32, key []byte) AdapterOptions {
+	return func(a *Adapter) error {
+		aead, err := newAEAD(id, key)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := a.keys[id]; ok {
+			return fmt.Errorf("encrypt adapter key ID %d is set twice", id)
+		}
+		a.keys[id] = aead
+
+		return nil
+	}
+}
+
+func newAEAD(id uint32, key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt adapter key %d: %w", id, err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,196 @@
+package encrypt
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cache "github.com/victorspringer/http-cache"
+	"github.com/victorspringer/http-cache/adapter/memory"
+)
+
+var (
+	oldKey = bytes.Repeat([]byte{1}, 32)
+	newKey = bytes.Repeat([]byte{2}, 32)
+)
+
+func newMemory(t *testing.T) cache.Adapter {
+	t.Helper()
+	m, err := memory.NewAdapter(memory.AdapterWithAlgorithm(memory.LRU), memory.AdapterWithCapacity(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestEncryptsAtRest(t *testing.T) {
+	backend := newMemory(t)
+	a, err := NewAdapter(backend, AdapterWithKey(1, oldKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret := []byte("card number 4111 1111 1111 1111")
+	a.Set(1, secret, time.Now().Add(time.Minute))
+	stored, _ := backend.Get(1)
+	if bytes.Contains(stored, []byte("4111")) {
+		t.Fatal("the backend holds the plaintext")
+	}
+	if got, ok := a.Get(1); !ok || !bytes.Equal(got, secret) {
+		t.Fatalf("Get() = %q, %v; want the plaintext", got, ok)
+	}
+
+	a.Release(1)
+	if _, ok := a.Get(1); ok {
+		t.Error("Get() after Release() found the entry")
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	backend := newMemory(t)
+	before, err := NewAdapter(backend, AdapterWithKey(1, oldKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	before.Set(1, []byte("old"), time.Time{})
+
+	after, err := NewAdapter(backend, AdapterWithKey(2, newKey), AdapterWithDecryptionKey(1, oldKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := after.Get(1); !ok || string(got) != "old" {
+		t.Fatalf("Get() of an entry under the previous key = %q, %v; want old", got, ok)
+	}
+	after.Set(2, []byte("new"), time.Time{})
+	if _, ok := before.Get(2); ok {
+		t.Error("an adapter without the new key decrypted its entry")
+	}
+
+	retired, err := NewAdapter(backend, AdapterWithKey(2, newKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := retired.Get(1); ok {
+		t.Error("Get() of an entry under a retired key ID found it")
+	}
+}
+
+// Tampered entries, entries moved to another cache key and plaintext
+// entries must be misses.
+func TestAuthenticationFailuresAreMisses(t *testing.T) {
+	backend := newMemory(t)
+	a, err := NewAdapter(backend, AdapterWithKey(1, oldKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Set(1, []byte("value"), time.Time{})
+	sealed, _ := backend.Get(1)
+
+	tampered := append([]byte(nil), sealed...)
+	tampered[len(tampered)-1] ^= 0x01
+	backend.Set(2, tampered, time.Time{})
+	backend.Set(3, sealed, time.Time{})
+	backend.Set(4, []byte("plaintext"), time.Time{})
+	for key := uint64(2); key <= 4; key++ {
+		if got, ok := a.Get(key); ok {
+			t.Errorf("Get(%d) = %q, want a miss", key, got)
+		}
+	}
+}
+
+func TestMiddlewareWithEncryptedAdapter(t *testing.T) {
+	a, err := NewAdapter(newMemory(t), AdapterWithKey(1, oldKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := cache.NewClient(cache.ClientWithAdapter(a), cache.ClientWithTTL(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte("private"))
+	}))
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://x/private", nil))
+		if w.Body.String() != "private" {
+			t.Fatalf("body = %q, want private", w.Body.String())
+		}
+	}
+	if calls != 1 {
+		t.Errorf("origin called %d times, want 1", calls)
+	}
+}
+
+func TestNewAdapterRejectsInvalidKeys(t *testing.T) {
+	m := newMemory(t)
+	for _, opts := range [][]AdapterOptions{
+		nil,
+		{AdapterWithKey(1, []byte("short"))},
+		{AdapterWithDecryptionKey(1, oldKey)},
+		{AdapterWithKey(1, oldKey), AdapterWithDecryptionKey(1, newKey)},
+		{AdapterWithDecryptionKey(1, oldKey), AdapterWithKey(1, newKey)},
+	} {
+		if _, err := NewAdapter(m, opts...); err == nil {
+			t.Errorf("NewAdapter() with %d options error = nil, want error", len(opts))
+		}
+	}
+	if _, err := NewAdapter(nil, AdapterWithKey(1, oldKey)); err == nil {
+		t.Error("NewAdapter(nil) error = nil, want error")
+	}
+}
+
+// countingMemory is a memory adapter counting the entries written.
+type countingMemory struct {
+	*memory.Adapter
+	sets atomic.Int32
+}
+
+func (m *countingMemory) SetWide(ctx context.Context, key string, response []byte, expiration time.Time) error {
+	m.sets.Add(1)
+	return m.Adapter.SetWide(ctx, key, response, expiration)
+}
+
+// Hits are recorded through the wrapped adapter, without sealing and
+// writing the entry again, and wide entries are bound to their key.
+func TestClientUsesWrappedAdapterInterfaces(t *testing.T) {
+	inner := &countingMemory{Adapter: newMemory(t).(*memory.Adapter)}
+	a, err := NewAdapter(inner, AdapterWithKey(1, oldKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := cache.NewClient(cache.ClientWithAdapter(a), cache.ClientWithTTL(time.Minute), cache.ClientWithWideKeys())
+	if err != nil {
+		t.Fatalf("NewClient() with wide keys error = %v", err)
+	}
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secret"))
+	}))
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://x/", nil))
+		if w.Body.String() != "secret" {
+			t.Fatalf("body = %q, want secret", w.Body)
+		}
+	}
+	if got := inner.sets.Load(); got != 1 {
+		t.Errorf("%d entries written, want 1: hits must be recorded with TouchWide", got)
+	}
+	if usage := client.Stats().Adapter; usage == nil || usage.Entries != 1 {
+		t.Errorf("Stats().Adapter = %+v, want 1 entry", usage)
+	}
+
+	ctx := context.Background()
+	wide := inner.WideKeys()[0]
+	stored, _, _ := inner.GetWide(ctx, wide)
+	inner.SetWide(ctx, "moved", stored, time.Now().Add(time.Minute))
+	if _, ok, _ := a.(*Adapter).GetWide(ctx, "moved"); ok {
+		t.Error("GetWide() of an entry moved to another wide key found it, want a miss")
+	}
+}
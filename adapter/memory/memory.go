@@ -25,9 +25,9 @@ SOFTWARE.
 package memory
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"math"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -68,7 +68,12 @@ type Adapter struct {
 	algorithm Algorithm
 	store     map[uint64][]byte
 	meta      map[uint64]*entry
+	wide      map[string][]byte
+	wideMeta  map[string]*entry
 	storage   storageControl
+	evictions uint64
+	notify    []func(cache.CacheEvent)
+	evicted   []cache.CacheEvent
 }
 
 // AdapterOptions is used to set Adapter settings.
@@ -90,8 +95,27 @@ func (a *Adapter) Get(key uint64) ([]byte, bool) {
 // Set implements the cache Adapter interface Set method.
 func (a *Adapter) Set(key uint64, response []byte, expiration time.Time) {
 	a.mutex.Lock()
-	defer a.mutex.Unlock()
+	a.set(key, response)
+	a.unlock()
+}
+
+// unlock releases the write lock, then passes the events of the entries
+// evicted under it on: notifying outside the lock lets observers use the
+// adapter.
+func (a *Adapter) unlock() {
+	evicted, notify := a.evicted, a.notify
+	a.evicted = nil
+	a.mutex.Unlock()
+
+	for _, event := range evicted {
+		for _, fn := range notify {
+			fn(event)
+		}
+	}
+}
 
+// set stores response under the write lock.
+func (a *Adapter) set(key uint64, response []byte) {
 	if a.meta == nil {
 		// Backstop for adapters constructed without NewAdapter (e.g.
 		// direct struct literals in tests).
@@ -104,25 +128,126 @@ func (a *Adapter) Set(key uint64, response []byte, expiration time.Time) {
 		a.storage.del(len(old))
 	}
 
-	if !a.storage.canCache(len(response)) {
+	if !a.makeRoom(len(response)) {
 		return
 	}
 
+	a.store[key] = response
+	a.meta[key] = newEntry(len(response))
+	a.storage.add(len(response))
+}
+
+// makeRoom evicts entries until a new one of size bytes fits, reporting
+// false when it cannot be cached. It assumes that the caller holds the
+// write lock.
+func (a *Adapter) makeRoom(size int) bool {
+	if !a.storage.canCache(size) {
+		return false
+	}
+
 	// New key, make sure we have the capacity.
-	if a.capacity > 0 && len(a.store) == a.capacity {
+	if a.capacity > 0 && len(a.store)+len(a.wide) >= a.capacity {
 		a.evict()
 	}
 
 	// now evict based on storage
-	for a.storage.shouldEvict(len(response)) {
+	for a.storage.shouldEvict(size) {
 		if !a.evict() {
-			return
+			return false
 		}
 	}
+	return true
+}
 
-	a.store[key] = response
-	a.meta[key] = newEntry(len(response))
-	a.storage.add(len(response))
+// GetContext implements the cache.ContextAdapter interface. Memory
+// operations neither block nor fail, so ctx is ignored and the error is
+// always nil.
+func (a *Adapter) GetContext(ctx context.Context, key uint64) ([]byte, bool, error) {
+	b, ok := a.Get(key)
+	return b, ok, nil
+}
+
+// SetContext implements the cache.ContextAdapter interface.
+func (a *Adapter) SetContext(ctx context.Context, key uint64, response []byte, expiration time.Time) error {
+	a.Set(key, response, expiration)
+	return nil
+}
+
+// ReleaseContext implements the cache.ContextAdapter interface.
+func (a *Adapter) ReleaseContext(ctx context.Context, key uint64) error {
+	a.Release(key)
+	return nil
+}
+
+// GetWide implements the cache.WideKeyAdapter interface. Wide keys share
+// the capacity and the eviction algorithm of the uint64 ones, but not
+// their key space.
+func (a *Adapter) GetWide(ctx context.Context, key string) ([]byte, bool, error) {
+	a.mutex.RLock()
+	response, ok := a.wide[key]
+	a.mutex.RUnlock()
+
+	return response, ok, nil
+}
+
+// SetWide implements the cache.WideKeyAdapter interface.
+func (a *Adapter) SetWide(ctx context.Context, key string, response []byte, expiration time.Time) error {
+	a.mutex.Lock()
+	if a.wide == nil {
+		a.wide = make(map[string][]byte)
+		a.wideMeta = make(map[string]*entry)
+	}
+
+	if old, ok := a.wide[key]; ok {
+		delete(a.wide, key)
+		delete(a.wideMeta, key)
+		a.storage.del(len(old))
+	}
+
+	if a.makeRoom(len(response)) {
+		a.wide[key] = response
+		a.wideMeta[key] = newEntry(len(response))
+		a.storage.add(len(response))
+	}
+	a.unlock()
+	return nil
+}
+
+// ReleaseWide implements the cache.WideKeyAdapter interface.
+func (a *Adapter) ReleaseWide(ctx context.Context, key string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if b, ok := a.wide[key]; ok {
+		delete(a.wide, key)
+		delete(a.wideMeta, key)
+		a.storage.del(len(b))
+	}
+	return nil
+}
+
+// TouchWide implements the cache.AdapterTouchWide optional interface.
+func (a *Adapter) TouchWide(key string) {
+	a.mutex.RLock()
+	e, ok := a.wideMeta[key]
+	a.mutex.RUnlock()
+	if !ok {
+		return
+	}
+	atomic.StoreInt64(&e.lastAccessNano, time.Now().UnixNano())
+	atomic.AddInt64(&e.frequency, 1)
+}
+
+// WideKeys implements the cache.AdapterWideKeys optional interface.
+func (a *Adapter) WideKeys() []string {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	keys := make([]string, 0, len(a.wide))
+	for k := range a.wide {
+		keys = append(keys, k)
+	}
+	return keys
 }
 
 // Touch implements the cache.AdapterTouch optional interface. It records
@@ -154,6 +279,54 @@ func (a *Adapter) Release(key uint64) {
 	a.storage.del(len(b))
 }
 
+// Keys implements the cache.AdapterKeys optional interface. It only
+// returns the uint64 keys; the entries stored under wide keys, which
+// share the capacity, are listed by WideKeys.
+func (a *Adapter) Keys() []uint64 {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	keys := make([]uint64, 0, len(a.store))
+	for k := range a.store {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Usage implements the cache.AdapterStats optional interface.
+func (a *Adapter) Usage() cache.AdapterUsage {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	return cache.AdapterUsage{
+		Entries:   len(a.store) + len(a.wide),
+		Bytes:     int64(a.storage.cur),
+		Evictions: a.evictions,
+	}
+}
+
+// Notify implements the cache.AdapterNotifier optional interface. fn
+// receives an eviction event for each entry released to make room for
+// another.
+func (a *Adapter) Notify(fn func(cache.CacheEvent)) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.notify = append(a.notify, fn)
+}
+
+// Flush implements the cache.AdapterFlush optional interface.
+func (a *Adapter) Flush() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.store = make(map[uint64][]byte, len(a.store))
+	a.meta = make(map[uint64]*entry, len(a.meta))
+	a.wide = nil
+	a.wideMeta = nil
+	a.storage.cur = 0
+}
+
 // newEntry seeds a fresh metadata entry. Set always starts an entry
 // with LastAccess=now and Frequency=1; Touch increments thereafter.
 // Previous versions would gob-decode the blob to read an embedded
@@ -169,66 +342,67 @@ func newEntry(size int) *entry {
 	}
 }
 
-// evict removes a single entry from the store. It assumes that the caller holds
-// the write lock.
+// evict removes a single entry from the store, uint64 and wide keys
+// alike. It assumes that the caller holds the write lock.
 func (a *Adapter) evict() bool {
 	var (
-		selectedKey uint64
-		selSize     int
-		hit         bool
+		selectedKey  uint64
+		selectedWide string
+		wide         bool
+		selSize      int
+		hit          bool
+		best         int64
 	)
 
-	switch a.algorithm {
-	case LRU:
-		oldest := int64(math.MaxInt64)
-		for k, e := range a.meta {
-			last := atomic.LoadInt64(&e.lastAccessNano)
-			if last < oldest {
-				oldest = last
-				selectedKey = k
-				selSize = e.size
-				hit = true
-			}
+	// LRU and LFU evict the lowest access time or frequency, keeping the
+	// first entry among equals; MRU and MFU the highest, keeping the last.
+	lowest := a.algorithm == LRU || a.algorithm == LFU
+	selects := func(e *entry) bool {
+		var v int64
+		switch a.algorithm {
+		case LRU, MRU:
+			v = atomic.LoadInt64(&e.lastAccessNano)
+		case LFU, MFU:
+			v = atomic.LoadInt64(&e.frequency)
+		default:
+			return false
 		}
-	case MRU:
-		newest := int64(math.MinInt64)
-		for k, e := range a.meta {
-			last := atomic.LoadInt64(&e.lastAccessNano)
-			if last >= newest {
-				newest = last
-				selectedKey = k
-				selSize = e.size
-				hit = true
-			}
+		if hit && (lowest && v >= best || !lowest && v < best) {
+			return false
 		}
-	case LFU:
-		lowest := int64(math.MaxInt64)
-		for k, e := range a.meta {
-			f := atomic.LoadInt64(&e.frequency)
-			if f < lowest {
-				lowest = f
-				selectedKey = k
-				selSize = e.size
-				hit = true
-			}
+		best, selSize, hit = v, e.size, true
+		return true
+	}
+	for k, e := range a.meta {
+		if selects(e) {
+			selectedKey, selectedWide, wide = k, "", false
 		}
-	case MFU:
-		highest := int64(math.MinInt64)
-		for k, e := range a.meta {
-			f := atomic.LoadInt64(&e.frequency)
-			if f >= highest {
-				highest = f
-				selectedKey = k
-				selSize = e.size
-				hit = true
-			}
+	}
+	for k, e := range a.wideMeta {
+		if selects(e) {
+			selectedKey, selectedWide, wide = 0, k, true
 		}
 	}
 
 	if hit {
+		a.evictions++
+		if len(a.notify) > 0 {
+			a.evicted = append(a.evicted, cache.CacheEvent{
+				Type:    cache.CacheEventEviction,
+				Key:     selectedKey,
+				WideKey: selectedWide,
+				Reason:  cache.ReasonCapacity,
+				Size:    selSize,
+			})
+		}
 		a.storage.del(selSize)
-		delete(a.store, selectedKey)
-		delete(a.meta, selectedKey)
+		if wide {
+			delete(a.wide, selectedWide)
+			delete(a.wideMeta, selectedWide)
+		} else {
+			delete(a.store, selectedKey)
+			delete(a.meta, selectedKey)
+		}
 	}
 	return hit
 }
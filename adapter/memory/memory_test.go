@@ -422,3 +422,75 @@ func TestSetOversizedOverwriteReleasesExistingResponse(t *testing.T) {
 		t.Fatalf("storage cur = %d, want 0", a.storage.cur)
 	}
 }
+
+func TestKeysAndFlush(t *testing.T) {
+	a, err := NewAdapter(AdapterWithAlgorithm(LRU), AdapterWithCapacity(10), AdapterWithStorageCapacity(1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []uint64{1, 2, 3} {
+		a.Set(key, cache.Response{Value: []byte("v")}.Bytes(), time.Now().Add(time.Minute))
+	}
+
+	keys := a.(cache.AdapterKeys).Keys()
+	if len(keys) != 3 {
+		t.Fatalf("Keys() returned %d keys, want 3", len(keys))
+	}
+
+	a.(cache.AdapterFlush).Flush()
+	if keys := a.(cache.AdapterKeys).Keys(); len(keys) != 0 {
+		t.Errorf("Keys() after Flush() returned %d keys, want 0", len(keys))
+	}
+	if _, ok := a.Get(1); ok {
+		t.Error("Get() after Flush() found an entry")
+	}
+	if cur := a.(*Adapter).storage.cur; cur != 0 {
+		t.Errorf("storage size after Flush() = %d, want 0", cur)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	a, err := NewAdapter(AdapterWithAlgorithm(LRU), AdapterWithCapacity(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := cache.Response{Value: []byte("v")}.Bytes()
+	for _, key := range []uint64{1, 2, 3} {
+		a.Set(key, value, time.Now().Add(time.Minute))
+	}
+
+	usage := a.(cache.AdapterStats).Usage()
+	want := cache.AdapterUsage{Entries: 2, Bytes: int64(2 * len(value)), Evictions: 1}
+	if usage != want {
+		t.Errorf("Usage() = %+v, want %+v", usage, want)
+	}
+}
+
+func TestNotifyReportsEvictions(t *testing.T) {
+	a, err := NewAdapter(AdapterWithAlgorithm(LRU), AdapterWithCapacity(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []cache.CacheEvent
+	a.(cache.AdapterNotifier).Notify(func(event cache.CacheEvent) {
+		// Observers may use the adapter while handling an event.
+		a.Get(event.Key)
+		events = append(events, event)
+	})
+
+	value := cache.Response{Value: []byte("v")}.Bytes()
+	for _, key := range []uint64{1, 2, 3} {
+		a.Set(key, value, time.Now().Add(time.Minute))
+		time.Sleep(time.Millisecond)
+	}
+
+	want := []cache.CacheEvent{{
+		Type:   cache.CacheEventEviction,
+		Key:    1,
+		Reason: cache.ReasonCapacity,
+		Size:   len(value),
+	}}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("events = %+v, want %+v", events, want)
+	}
+}
@@ -0,0 +1,119 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	cache "github.com/victorspringer/http-cache"
+)
+
+// Wide keys live next to the uint64 ones: the same numeric value in both
+// key spaces must not share an entry.
+func TestWideKeysAreSeparateFromUint64Keys(t *testing.T) {
+	a, err := NewAdapter(
+		AdapterWithCapacity(4),
+		AdapterWithAlgorithm(LRU),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wa, ok := a.(cache.WideKeyAdapter)
+	if !ok {
+		t.Fatal("memory.Adapter does not implement cache.WideKeyAdapter")
+	}
+	ctx := context.Background()
+	expiration := time.Now().Add(time.Hour)
+
+	a.Set(1, []byte("narrow"), expiration)
+	if err := wa.SetWide(ctx, "1", []byte("wide"), expiration); err != nil {
+		t.Fatal(err)
+	}
+	if err := wa.SetWide(ctx, "2", []byte("other"), expiration); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, ok := a.Get(1); !ok || string(b) != "narrow" {
+		t.Errorf("Get(1) = %q, %v; want \"narrow\", true", b, ok)
+	}
+	if b, ok, err := wa.GetWide(ctx, "1"); err != nil || !ok || string(b) != "wide" {
+		t.Errorf("GetWide(\"1\") = %q, %v, %v; want \"wide\", true, nil", b, ok, err)
+	}
+	keys := a.(cache.AdapterWideKeys).WideKeys()
+	sort.Strings(keys)
+	if len(keys) != 2 || keys[0] != "1" || keys[1] != "2" {
+		t.Errorf("WideKeys() = %v, want [1 2]", keys)
+	}
+	if got := a.(cache.AdapterStats).Usage().Entries; got != 3 {
+		t.Errorf("Usage().Entries = %d, want 3", got)
+	}
+
+	if err := wa.ReleaseWide(ctx, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := wa.GetWide(ctx, "1"); ok {
+		t.Error("GetWide(\"1\") found a released entry")
+	}
+	if _, ok := a.Get(1); !ok {
+		t.Error("ReleaseWide(\"1\") released the uint64 entry 1")
+	}
+
+	a.(cache.AdapterFlush).Flush()
+	if _, ok, _ := wa.GetWide(ctx, "2"); ok {
+		t.Error("Flush() left a wide entry")
+	}
+}
+
+// Wide and uint64 entries share the capacity: eviction picks among both.
+func TestWideKeysShareCapacity(t *testing.T) {
+	a, err := NewAdapter(
+		AdapterWithCapacity(2),
+		AdapterWithAlgorithm(LFU),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wa := a.(cache.WideKeyAdapter)
+	var events []cache.CacheEvent
+	a.(cache.AdapterNotifier).Notify(func(e cache.CacheEvent) {
+		events = append(events, e)
+	})
+	ctx := context.Background()
+	expiration := time.Now().Add(time.Hour)
+
+	a.Set(1, []byte("narrow"), expiration)
+	wa.SetWide(ctx, "hot", []byte("wide"), expiration)
+	a.(cache.AdapterTouchWide).TouchWide("hot")
+	a.(cache.AdapterTouch).Touch(1)
+	a.(cache.AdapterTouch).Touch(1)
+
+	// The wide entry is the least frequently used one.
+	a.Set(2, []byte("new"), expiration)
+	if _, ok, _ := wa.GetWide(ctx, "hot"); ok {
+		t.Error("LFU did not evict the least-frequent wide entry")
+	}
+	if _, ok := a.Get(1); !ok {
+		t.Error("LFU evicted the hottest uint64 entry")
+	}
+
+	// A wide entry can evict a uint64 one in turn.
+	wa.SetWide(ctx, "new", []byte("wide"), expiration)
+	if _, ok := a.Get(2); ok {
+		t.Error("LFU did not evict the least-frequent uint64 entry")
+	}
+	if got := a.(cache.AdapterStats).Usage().Entries; got != 2 {
+		t.Errorf("Usage().Entries = %d, want 2", got)
+	}
+
+	// Eviction events name the evicted entry by its own key.
+	if len(events) != 2 {
+		t.Fatalf("got %d eviction events, want 2", len(events))
+	}
+	if events[0].Key != 0 || events[0].WideKey != "hot" {
+		t.Errorf("wide eviction event keys = %d, %q; want 0, \"hot\"", events[0].Key, events[0].WideKey)
+	}
+	if events[1].Key != 2 || events[1].WideKey != "" {
+		t.Errorf("uint64 eviction event keys = %d, %q; want 2, \"\"", events[1].Key, events[1].WideKey)
+	}
+}
@@ -25,6 +25,7 @@ SOFTWARE.
 package redis
 
 import (
+	"context"
 	"time"
 
 	redisCache "github.com/go-redis/cache"
@@ -43,31 +44,111 @@ type RingOptions redis.RingOptions
 
 // Get implements the cache Adapter interface Get method.
 func (a *Adapter) Get(key uint64) ([]byte, bool) {
-	var c []byte
-	if err := a.store.Get(cache.KeyAsString(key), &c); err == nil {
-		return c, true
-	}
-
-	return nil, false
+	b, ok, _ := a.GetContext(context.Background(), key)
+	return b, ok
 }
 
 // Set implements the cache Adapter interface Set method.
 func (a *Adapter) Set(key uint64, response []byte, expiration time.Time) {
-	duration := time.Duration(0)
-	if !expiration.IsZero() {
-		duration = expiration.Sub(time.Now())
+	a.SetContext(context.Background(), key, response, expiration)
+}
+
+// Release implements the cache Adapter interface Release method.
+func (a *Adapter) Release(key uint64) {
+	a.ReleaseContext(context.Background(), key)
+}
+
+// GetContext implements the cache.ContextAdapter interface. Connection
+// and timeout errors are returned; a missing key is not an error. go-redis
+// v6 does not interrupt a command when ctx is done, so set the client
+// ReadTimeout and WriteTimeout to bound how long a call can take.
+func (a *Adapter) GetContext(ctx context.Context, key uint64) ([]byte, bool, error) {
+	return a.get(ctx, cache.KeyAsString(key))
+}
+
+// SetContext implements the cache.ContextAdapter interface.
+func (a *Adapter) SetContext(ctx context.Context, key uint64, response []byte, expiration time.Time) error {
+	return a.set(ctx, cache.KeyAsString(key), response, expiration)
+}
+
+// ReleaseContext implements the cache.ContextAdapter interface.
+func (a *Adapter) ReleaseContext(ctx context.Context, key uint64) error {
+	return a.release(ctx, cache.KeyAsString(key))
+}
+
+// widePrefix sets the Redis keys of wide entries apart from the uint64
+// ones.
+const widePrefix = "wide:"
+
+// GetWide implements the cache.WideKeyAdapter interface, with the same
+// error semantics as GetContext.
+func (a *Adapter) GetWide(ctx context.Context, key string) ([]byte, bool, error) {
+	return a.get(ctx, widePrefix+key)
+}
+
+// SetWide implements the cache.WideKeyAdapter interface.
+func (a *Adapter) SetWide(ctx context.Context, key string, response []byte, expiration time.Time) error {
+	return a.set(ctx, widePrefix+key, response, expiration)
+}
+
+// ReleaseWide implements the cache.WideKeyAdapter interface.
+func (a *Adapter) ReleaseWide(ctx context.Context, key string) error {
+	return a.release(ctx, widePrefix+key)
+}
+
+func (a *Adapter) get(ctx context.Context, key string) ([]byte, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+
+	var c []byte
+	switch err := a.store.GetContext(ctx, key, &c); err {
+	case nil:
+		return c, true, nil
+	case redisCache.ErrCacheMiss:
+		return nil, false, nil
+	default:
+		return nil, false, err
+	}
+}
+
+func (a *Adapter) set(ctx context.Context, key string, response []byte, expiration time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
-	a.store.Set(&redisCache.Item{
-		Key:        cache.KeyAsString(key),
+	return a.store.Set(&redisCache.Item{
+		Ctx:        ctx,
+		Key:        key,
 		Object:     response,
-		Expiration: duration,
+		Expiration: ttl(expiration, time.Now()),
 	})
 }
 
-// Release implements the cache Adapter interface Release method.
-func (a *Adapter) Release(key uint64) {
-	a.store.Delete(cache.KeyAsString(key))
+// ttl returns the go-redis/cache Item expiration for an entry expiring
+// at expiration. go-redis/cache keeps negative durations forever and
+// raises the ones under a second to an hour, so entries that are about to
+// expire, or already have, are given a second.
+func ttl(expiration, now time.Time) time.Duration {
+	if expiration.IsZero() {
+		return 0
+	}
+	if d := expiration.Sub(now); d >= time.Second {
+		return d
+	}
+	return time.Second
+}
+
+func (a *Adapter) release(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	err := a.store.DeleteContext(ctx, key)
+	if err == redisCache.ErrCacheMiss {
+		return nil
+	}
+	return err
 }
 
 // NewAdapter initializes Redis adapter.
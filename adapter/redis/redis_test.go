@@ -1,6 +1,9 @@
 package redis
 
 import (
+	"context"
+	"errors"
+	"net"
 	"reflect"
 	"testing"
 	"time"
@@ -207,3 +210,100 @@ func TestNewAdapterWithClient(t *testing.T) {
 		t.Fatalf("memory.Get() = %v, want %v", string(got), "standalone client")
 	}
 }
+
+func TestContextAdapterReportsErrors(t *testing.T) {
+	client := goredis.NewClient(&goredis.Options{
+		Dialer: func() (net.Conn, error) {
+			return nil, errors.New("connection refused")
+		},
+	})
+	defer client.Close()
+	adapter := NewAdapterWithClient(client).(cache.ContextAdapter)
+
+	if _, ok, err := adapter.GetContext(context.Background(), 1); ok || err == nil {
+		t.Errorf("GetContext() on an unreachable server = %v, %v; want an error", ok, err)
+	}
+	if err := adapter.SetContext(context.Background(), 1, []byte("v"), time.Now().Add(time.Minute)); err == nil {
+		t.Error("SetContext() on an unreachable server error = nil, want error")
+	}
+	if err := adapter.ReleaseContext(context.Background(), 1); err == nil {
+		t.Error("ReleaseContext() on an unreachable server error = nil, want error")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := adapter.GetContext(ctx, 1); err != context.Canceled {
+		t.Errorf("GetContext() with a canceled context error = %v, want context.Canceled", err)
+	}
+}
+
+func TestWideKeyAdapter(t *testing.T) {
+	client := goredis.NewClient(&goredis.Options{
+		Addr: ":6379",
+	})
+	defer client.Close()
+
+	adapter := NewAdapterWithClient(client)
+	requireRedis(t, adapter)
+	wide := adapter.(cache.WideKeyAdapter)
+	ctx := context.Background()
+	key := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	t.Cleanup(func() {
+		wide.ReleaseWide(ctx, key)
+	})
+
+	if err := wide.SetWide(ctx, key, []byte("wide"), time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("SetWide() error = %v", err)
+	}
+	b, ok, err := wide.GetWide(ctx, key)
+	if err != nil || !ok || string(b) != "wide" {
+		t.Fatalf("GetWide() = %q, %v, %v; want \"wide\", true, nil", b, ok, err)
+	}
+	if err := wide.ReleaseWide(ctx, key); err != nil {
+		t.Fatalf("ReleaseWide() error = %v", err)
+	}
+	if _, ok, err := wide.GetWide(ctx, key); ok || err != nil {
+		t.Errorf("GetWide() after ReleaseWide() = %v, %v; want false, nil", ok, err)
+	}
+}
+
+func TestWideKeyAdapterReportsErrors(t *testing.T) {
+	client := goredis.NewClient(&goredis.Options{
+		Dialer: func() (net.Conn, error) {
+			return nil, errors.New("connection refused")
+		},
+	})
+	defer client.Close()
+	adapter := NewAdapterWithClient(client).(cache.WideKeyAdapter)
+
+	if _, ok, err := adapter.GetWide(context.Background(), "k"); ok || err == nil {
+		t.Errorf("GetWide() on an unreachable server = %v, %v; want an error", ok, err)
+	}
+	if err := adapter.SetWide(context.Background(), "k", []byte("v"), time.Now().Add(time.Minute)); err == nil {
+		t.Error("SetWide() on an unreachable server error = nil, want error")
+	}
+	if err := adapter.ReleaseWide(context.Background(), "k"); err == nil {
+		t.Error("ReleaseWide() on an unreachable server error = nil, want error")
+	}
+}
+
+func TestTTLClampsShortExpirations(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name       string
+		expiration time.Time
+		want       time.Duration
+	}{
+		{"no expiration", time.Time{}, 0},
+		{"minutes ahead", now.Add(time.Minute), time.Minute},
+		{"under a second ahead", now.Add(100 * time.Millisecond), time.Second},
+		{"in the past", now.Add(-time.Minute), time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ttl(tt.expiration, now); got != tt.want {
+				t.Errorf("ttl() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
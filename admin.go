@@ -0,0 +1,374 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultAdminKeysLimit caps the entries listed by the admin /keys
+// endpoint when no limit is given.
+const defaultAdminKeysLimit = 1000
+
+// cacheTagHeader is the response header listing the comma-separated tags
+// of an entry, matched by the admin /purge?tag endpoint.
+const cacheTagHeader = "Cache-Tag"
+
+// AdminHandler returns an http.Handler exposing JSON endpoints to inspect
+// and purge the cache:
+//
+//	GET  /stats                       client settings, Stats and entry count
+//	GET  /entry?url=U[&header=N:V]    inspect the entry cached for U
+//	GET  /keys[?prefix=P][&limit=N]   list cached entries (AdapterKeys)
+//	POST /purge?url=U[&header=N:V]    purge the entry cached for U
+//	POST /purge?prefix=P              purge entries whose URL starts with P (AdapterKeys)
+//	POST /purge?tag=T                 purge entries tagged T (AdapterKeys)
+//	POST /flush                       release every entry (AdapterFlush or AdapterKeys)
+//
+// A server keys requests on the path and query of their request line, so
+// U and P are reduced to their path and query: http://host/p?q and /p?q
+// name the same entry. header can be repeated to select a vary variant.
+// The tags of an entry
+// are the comma-separated values of the Cache-Tag header the origin sent
+// with it; tag and prefix can be combined. Purges honor
+// ClientWithSoftPurge. With ClientWithNamespace, /keys, /purge?prefix,
+// /purge?tag and /flush only see the entries of the client's namespace,
+// and /flush needs AdapterKeys.
+//
+// With ClientWithWideKeys, entries are enumerated through
+// AdapterWideKeys as well and listed with their wide key.
+//
+// The handler performs no authentication: mount it behind your own auth,
+// e.g.
+//
+//	http.Handle("/cache/", http.StripPrefix("/cache", auth(client.AdminHandler())))
+func (c *Client) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /stats", c.adminStats)
+	mux.HandleFunc("GET /entry", c.adminEntry)
+	mux.HandleFunc("GET /keys", c.adminKeys)
+	mux.HandleFunc("POST /purge", c.adminPurge)
+	mux.HandleFunc("POST /flush", c.adminFlush)
+	return mux
+}
+
+type adminEntry struct {
+	Key              string      `json:"key"`
+	URL              string      `json:"url,omitempty"`
+	StatusCode       int         `json:"statusCode"`
+	Header           http.Header `json:"header,omitempty"`
+	Size             int         `json:"size"`
+	Expiration       *time.Time  `json:"expiration,omitempty"`
+	Age              float64     `json:"age"`
+	TTL              float64     `json:"ttl"`
+	Stale            bool        `json:"stale"`
+	FingerprintMatch bool        `json:"fingerprintMatch"`
+	Body             []byte      `json:"body,omitempty"`
+}
+
+func (c *Client) adminStats(w http.ResponseWriter, r *http.Request) {
+	stats := map[string]interface{}{
+		"ttl":          c.ttl.Seconds(),
+		"staleWindow":  c.staleWindow.Seconds(),
+		"softPurge":    c.softPurge,
+		"singleflight": c.singleflightEnabled,
+		"stats":        c.Stats(),
+	}
+	if keys, ok := c.storedKeys(); ok {
+		stats["entries"] = len(keys)
+	}
+	writeAdminJSON(w, http.StatusOK, stats)
+}
+
+func (c *Client) adminEntry(w http.ResponseWriter, r *http.Request) {
+	req, err := adminTargetRequest(r)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+	entry, err := c.Lookup(r.Context(), req)
+	switch {
+	case errors.Is(err, ErrNotCached):
+		writeAdminError(w, http.StatusNotFound, err)
+		return
+	case err != nil:
+		writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	out := adminEntry{
+		Key:              KeyAsString(entry.Key),
+		URL:              entry.Response.URL,
+		StatusCode:       entry.StatusCode,
+		Header:           cloneHeader(entry.Response.Header),
+		Size:             len(entry.Response.Value),
+		Age:              entry.Age.Seconds(),
+		TTL:              entry.TTL.Seconds(),
+		Stale:            entry.Stale,
+		FingerprintMatch: entry.FingerprintMatch,
+	}
+	out.Header.Del(cacheStatusCodeHeader)
+	if !entry.Response.Expiration.IsZero() {
+		out.Expiration = &entry.Response.Expiration
+	}
+	if b, _ := strconv.ParseBool(r.URL.Query().Get("body")); b {
+		out.Body = entry.Response.Value
+	}
+	writeAdminJSON(w, http.StatusOK, out)
+}
+
+// storedKey is a key enumerated from the adapter: a uint64 key, or a
+// WideKeyAdapter key when wide is set.
+type storedKey struct {
+	key  uint64
+	wide string
+}
+
+// String returns the key as reported by the admin handler.
+func (k storedKey) String() string {
+	if k.wide != "" {
+		return k.wide
+	}
+	return KeyAsString(k.key)
+}
+
+// storedKeys enumerates the adapter keys through AdapterKeys and
+// AdapterWideKeys, reporting false when the adapter has neither.
+func (c *Client) storedKeys() ([]storedKey, bool) {
+	a, hasKeys := adapterAs[AdapterKeys](c.adapter)
+	wa, hasWide := adapterAs[AdapterWideKeys](c.adapter)
+	var keys []storedKey
+	if hasKeys {
+		for _, key := range a.Keys() {
+			keys = append(keys, storedKey{key: key})
+		}
+	}
+	if hasWide {
+		for _, wide := range wa.WideKeys() {
+			keys = append(keys, storedKey{wide: wide})
+		}
+	}
+	return keys, hasKeys || hasWide
+}
+
+func (c *Client) adminKeys(w http.ResponseWriter, r *http.Request) {
+	keys, ok := c.storedKeys()
+	if !ok {
+		writeAdminError(w, http.StatusNotImplemented, errors.New("cache: adapter cannot enumerate keys"))
+		return
+	}
+	limit := defaultAdminKeysLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeAdminError(w, http.StatusBadRequest, errors.New("cache: invalid limit"))
+			return
+		}
+		limit = n
+	}
+	prefix := adminPrefix(r.URL.Query().Get("prefix"))
+
+	now := time.Now()
+	entries := []adminEntry{}
+	for _, key := range keys {
+		if len(entries) == limit {
+			break
+		}
+		b, ok, err := c.get(r.Context(), key.key, key.wide)
+		if err != nil || !ok {
+			continue
+		}
+		response, err := c.decode(b)
+		if err != nil || !c.ownsEntry(response) || !strings.HasPrefix(response.URL, prefix) {
+			continue
+		}
+		e := adminEntry{
+			Key:        key.String(),
+			URL:        response.URL,
+			StatusCode: response.statusCode(),
+			Size:       len(response.Value),
+			Stale:      !response.Valid(),
+		}
+		if !response.StoredAt.IsZero() {
+			e.Age = now.Sub(response.StoredAt).Seconds()
+		}
+		if !response.Expiration.IsZero() {
+			e.Expiration = &response.Expiration
+			e.TTL = response.Expiration.Sub(now).Seconds()
+		}
+		entries = append(entries, e)
+	}
+	writeAdminJSON(w, http.StatusOK, map[string]interface{}{"keys": entries})
+}
+
+func (c *Client) adminPurge(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	switch {
+	case q.Get("prefix") != "" || q.Get("tag") != "":
+		keys, ok := c.storedKeys()
+		if !ok {
+			writeAdminError(w, http.StatusNotImplemented, errors.New("cache: adapter cannot enumerate keys"))
+			return
+		}
+		prefix, tag := adminPrefix(q.Get("prefix")), q.Get("tag")
+		purged := 0
+		for _, key := range keys {
+			b, ok, err := c.get(r.Context(), key.key, key.wide)
+			if err != nil || !ok {
+				continue
+			}
+			if response, err := c.decode(b); err == nil && c.ownsEntry(response) && strings.HasPrefix(response.URL, prefix) && (tag == "" || hasTag(response, tag)) {
+				if err := c.purge(r.Context(), key.key, key.wide); err != nil {
+					writeAdminError(w, http.StatusBadGateway, err)
+					return
+				}
+				c.observe(CacheEventPurge, r, key.key, key.wide, 0)
+				purged++
+			}
+		}
+		writeAdminJSON(w, http.StatusOK, map[string]int{"purged": purged})
+	default:
+		req, err := adminTargetRequest(r)
+		if err != nil {
+			writeAdminError(w, http.StatusBadRequest, err)
+			return
+		}
+		key, fingerprint, err := c.requestKey(req)
+		if err != nil {
+			writeAdminError(w, http.StatusBadRequest, err)
+			return
+		}
+		wide := c.wideKey(fingerprint)
+		if err := c.purge(r.Context(), key, wide); err != nil {
+			writeAdminError(w, http.StatusBadGateway, err)
+			return
+		}
+		c.observe(CacheEventPurge, req, key, wide, 0)
+		writeAdminJSON(w, http.StatusOK, map[string]string{"key": KeyAsString(key)})
+	}
+}
+
+func (c *Client) adminFlush(w http.ResponseWriter, r *http.Request) {
+	a, canFlush := adapterAs[AdapterFlush](c.adapter)
+	keys, hasKeys := c.storedKeys()
+	switch {
+	case canFlush && c.namespace == "":
+		a.Flush()
+	case hasKeys:
+		for _, key := range keys {
+			if c.namespace != "" {
+				// Leave the entries of other namespaces alone.
+				b, ok, err := c.get(r.Context(), key.key, key.wide)
+				if err != nil || !ok {
+					continue
+				}
+				if response, err := c.decode(b); err != nil || !c.ownsEntry(response) {
+					continue
+				}
+			}
+			if err := c.release(r.Context(), key.key, key.wide); err != nil {
+				writeAdminError(w, http.StatusBadGateway, err)
+				return
+			}
+		}
+	default:
+		writeAdminError(w, http.StatusNotImplemented, errors.New("cache: adapter cannot be flushed"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// hasTag reports whether response was tagged with tag.
+func hasTag(response Response, tag string) bool {
+	response.materialize()
+	for _, v := range response.Header.Values(cacheTagHeader) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.TrimSpace(t) == tag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// adminTargetRequest builds the GET request an admin call refers to from
+// its url and header query parameters.
+func adminTargetRequest(r *http.Request) (*http.Request, error) {
+	q := r.URL.Query()
+	target := q.Get("url")
+	if target == "" {
+		return nil, errors.New("cache: url is not set")
+	}
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.URL = requestLineURL(req.URL)
+	for _, h := range q["header"] {
+		name, value, ok := strings.Cut(h, ":")
+		if !ok {
+			return nil, errors.New("cache: header must be formatted as Name:Value")
+		}
+		req.Header.Add(textproto.TrimString(name), textproto.TrimString(value))
+	}
+	return req, nil
+}
+
+// requestLineURL returns the URL a server sees for a request to u: its
+// path and query, without the scheme and host the middleware does not
+// key on.
+func requestLineURL(u *url.URL) *url.URL {
+	line := &url.URL{Path: u.Path, RawPath: u.RawPath, RawQuery: u.RawQuery}
+	if line.Path == "" {
+		line.Path = "/"
+	}
+	return line
+}
+
+// adminPrefix reduces an absolute URL prefix to its path and query, like
+// the url parameter.
+func adminPrefix(prefix string) string {
+	if u, err := url.Parse(prefix); err == nil && u.IsAbs() {
+		return requestLineURL(u).String()
+	}
+	return prefix
+}
+
+func writeAdminJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAdminError(w http.ResponseWriter, statusCode int, err error) {
+	writeAdminJSON(w, statusCode, map[string]string{"error": err.Error()})
+}
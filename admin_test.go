@@ -0,0 +1,215 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"testing"
+	"time"
+)
+
+// keyedAdapter is an adapterMock that can enumerate its keys.
+type keyedAdapter struct {
+	adapterMock
+}
+
+func (a *keyedAdapter) Keys() []uint64 {
+	a.Lock()
+	defer a.Unlock()
+	keys := make([]uint64, 0, len(a.store))
+	for k := range a.store {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+func newAdminTestClient(t *testing.T, adapter Adapter, opts ...ClientOption) (*Client, http.Handler) {
+	t.Helper()
+	client, err := NewClient(append([]ClientOption{ClientWithAdapter(adapter), ClientWithTTL(time.Minute)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, r.URL.Path)
+	}))
+	for _, p := range []string{"/a/1", "/a/2", "/b/1"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, p, nil))
+	}
+	return client, client.AdminHandler()
+}
+
+func serveAdmin(admin http.Handler, method, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+	return w
+}
+
+func TestAdminHandlerEntry(t *testing.T) {
+	_, admin := newAdminTestClient(t, &keyedAdapter{adapterMock{store: map[uint64][]byte{}}})
+
+	w := serveAdmin(admin, http.MethodGet, "/entry?body=1&url="+url.QueryEscape("http://x/a/1"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /entry status = %d, body %s", w.Code, w.Body.String())
+	}
+	var entry adminEntry
+	if err := json.Unmarshal(w.Body.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.URL != "/a/1" || entry.StatusCode != http.StatusOK || string(entry.Body) != "/a/1" {
+		t.Errorf("entry = %+v, want the cached /a/1 response", entry)
+	}
+	if entry.Key != KeyAsString(generateKey("/a/1")) {
+		t.Errorf("entry key = %s, want the middleware key", entry.Key)
+	}
+
+	if w := serveAdmin(admin, http.MethodGet, "/entry?url="+url.QueryEscape("http://x/none")); w.Code != http.StatusNotFound {
+		t.Errorf("GET /entry for an uncached URL status = %d, want 404", w.Code)
+	}
+	if w := serveAdmin(admin, http.MethodGet, "/entry"); w.Code != http.StatusBadRequest {
+		t.Errorf("GET /entry without url status = %d, want 400", w.Code)
+	}
+}
+
+func TestAdminHandlerKeysAndStats(t *testing.T) {
+	_, admin := newAdminTestClient(t, &keyedAdapter{adapterMock{store: map[uint64][]byte{}}})
+
+	w := serveAdmin(admin, http.MethodGet, "/keys?prefix="+url.QueryEscape("http://x/a/"))
+	var keys struct {
+		Keys []adminEntry `json:"keys"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &keys); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys.Keys) != 2 {
+		t.Errorf("GET /keys?prefix listed %d entries, want 2", len(keys.Keys))
+	}
+
+	w = serveAdmin(admin, http.MethodGet, "/stats")
+	var stats map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats["entries"] != float64(3) {
+		t.Errorf("stats entries = %v, want 3", stats["entries"])
+	}
+}
+
+func TestAdminHandlerPurge(t *testing.T) {
+	adapter := &keyedAdapter{adapterMock{store: map[uint64][]byte{}}}
+	_, admin := newAdminTestClient(t, adapter)
+
+	if w := serveAdmin(admin, http.MethodPost, "/purge?url="+url.QueryEscape("http://x/b/1")); w.Code != http.StatusOK {
+		t.Fatalf("POST /purge?url status = %d, body %s", w.Code, w.Body.String())
+	}
+	if _, ok := adapter.Get(generateKey("/b/1")); ok {
+		t.Error("entry still cached after purge by URL")
+	}
+
+	w := serveAdmin(admin, http.MethodPost, "/purge?prefix="+url.QueryEscape("http://x/a/"))
+	if w.Code != http.StatusOK || w.Body.String() != "{\"purged\":2}\n" {
+		t.Fatalf("POST /purge?prefix = %d %s, want 2 purged", w.Code, w.Body.String())
+	}
+	if len(adapter.Keys()) != 0 {
+		t.Errorf("%d entries left after purge by prefix, want 0", len(adapter.Keys()))
+	}
+
+	if w := serveAdmin(admin, http.MethodGet, "/purge?url=http://x/a/1"); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /purge status = %d, want 405", w.Code)
+	}
+}
+
+func TestAdminHandlerFlush(t *testing.T) {
+	adapter := &keyedAdapter{adapterMock{store: map[uint64][]byte{}}}
+	_, admin := newAdminTestClient(t, adapter)
+
+	if w := serveAdmin(admin, http.MethodPost, "/flush"); w.Code != http.StatusNoContent {
+		t.Fatalf("POST /flush status = %d, want 204", w.Code)
+	}
+	if len(adapter.Keys()) != 0 {
+		t.Errorf("%d entries left after flush, want 0", len(adapter.Keys()))
+	}
+
+	// Without AdapterKeys or AdapterFlush there is nothing to enumerate.
+	_, admin = newAdminTestClient(t, &adapterMock{store: map[uint64][]byte{}})
+	if w := serveAdmin(admin, http.MethodPost, "/flush"); w.Code != http.StatusNotImplemented {
+		t.Errorf("POST /flush status = %d, want 501", w.Code)
+	}
+	if w := serveAdmin(admin, http.MethodGet, "/keys"); w.Code != http.StatusNotImplemented {
+		t.Errorf("GET /keys status = %d, want 501", w.Code)
+	}
+}
+
+func TestAdminHandlerPurgeByTag(t *testing.T) {
+	adapter := &keyedAdapter{adapterMock{store: map[uint64][]byte{}}}
+	client, err := NewClient(ClientWithAdapter(adapter), ClientWithTTL(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := map[string]string{
+		"/a/1": "news, sports",
+		"/a/2": "sports",
+		"/b/1": "news",
+	}
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Tag", tags[r.URL.Path])
+		fmt.Fprint(w, r.URL.Path)
+	}))
+	for p := range tags {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, p, nil))
+	}
+	admin := client.AdminHandler()
+
+	w := serveAdmin(admin, http.MethodPost, "/purge?tag=news&prefix="+url.QueryEscape("http://x/a/"))
+	if w.Code != http.StatusOK || w.Body.String() != "{\"purged\":1}\n" {
+		t.Fatalf("POST /purge?tag&prefix = %d %s, want 1 purged", w.Code, w.Body.String())
+	}
+	w = serveAdmin(admin, http.MethodPost, "/purge?tag=news")
+	if w.Code != http.StatusOK || w.Body.String() != "{\"purged\":1}\n" {
+		t.Fatalf("POST /purge?tag = %d %s, want 1 purged", w.Code, w.Body.String())
+	}
+	if _, ok := adapter.Get(generateKey("/a/2")); !ok || len(adapter.Keys()) != 1 {
+		t.Errorf("purge by tag left %d entries, want only /a/2, tagged sports", len(adapter.Keys()))
+	}
+}
+
+// Entries stored from live traffic, keyed on the path and query of the
+// request line, are found by the absolute URL given to the admin handler.
+func TestAdminHandlerTargetsLiveTraffic(t *testing.T) {
+	client, err := NewClient(ClientWithAdapter(&keyedAdapter{adapterMock{store: map[uint64][]byte{}}}), ClientWithTTL(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	srv := httptest.NewServer(client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		fmt.Fprint(w, "ok")
+	})))
+	defer srv.Close()
+	fetch := func() {
+		t.Helper()
+		resp, err := srv.Client().Get(srv.URL + "/p?b=2&a=1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	fetch()
+	admin := client.AdminHandler()
+	target := url.QueryEscape(srv.URL + "/p?a=1&b=2")
+	if w := serveAdmin(admin, http.MethodGet, "/entry?url="+target); w.Code != http.StatusOK {
+		t.Fatalf("GET /entry = %d %s, want the entry", w.Code, w.Body.String())
+	}
+	if w := serveAdmin(admin, http.MethodPost, "/purge?url="+target); w.Code != http.StatusOK {
+		t.Fatalf("POST /purge = %d %s", w.Code, w.Body.String())
+	}
+	fetch()
+	if calls != 2 {
+		t.Errorf("origin called %d times, want 2: the purge missed the entry", calls)
+	}
+}
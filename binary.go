@@ -0,0 +1,308 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cache
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// CodecIDBinary identifies BinaryCodec.
+const CodecIDBinary byte = 5
+
+// BinaryCodec encodes responses in a compact layout built for the hit
+// path: decoding only allocates the URL and namespace strings, the header
+// is only parsed, allocating its names and values, when it is written to
+// a response, and the body is served straight from the adapter's slice.
+// All integers are big-endian:
+//
+//	layout version     1 byte, currently 1
+//	status code        uint16
+//	expiration         int64 Unix nanoseconds, 0 if zero
+//	stored at          int64 Unix nanoseconds, 0 if zero
+//	last access        int64 Unix nanoseconds, 0 if zero
+//	origin duration    int64 nanoseconds
+//	frequency          int64
+//	canonical key      uint32 length, bytes
+//	URL                uint32 length, bytes
+//	namespace          uint32 length, bytes
+//	header             uint32 length, then per name: uint16 length,
+//	                   name, uint16 value count, per value: uint32
+//	                   length, value
+//	value              uint32 length, bytes
+type BinaryCodec struct{}
+
+const (
+	binaryLayoutVersion = 1
+	binaryFixedSize     = 1 + 2 + 5*8
+)
+
+var errBinaryTruncated = errors.New("cache: truncated binary entry")
+
+// ID implements the Codec interface.
+func (BinaryCodec) ID() byte { return CodecIDBinary }
+
+// Marshal implements the Codec interface.
+func (BinaryCodec) Marshal(r Response) ([]byte, error) {
+	return BinaryCodec{}.appendTo(nil, r)
+}
+
+// Unmarshal implements the Codec interface. Value and CanonicalKey are
+// copied out of b.
+func (BinaryCodec) Unmarshal(b []byte, r *Response) error {
+	if err := decodeBinary(b, r); err != nil {
+		return err
+	}
+	r.materialize()
+	return nil
+}
+
+// appendTo implements the codecAppender interface.
+func (BinaryCodec) appendTo(dst []byte, r Response) ([]byte, error) {
+	r.materialize()
+	status := cachedStatusCode(r.Header)
+
+	size := binaryFixedSize + 4 + len(r.CanonicalKey) + 4 + len(r.URL) + 4 + len(r.Namespace) + 4 + 4 + len(r.Value)
+	for name, values := range r.Header {
+		if name == cacheStatusCodeHeader {
+			continue
+		}
+		if len(name) > 0xffff || len(values) > 0xffff {
+			return nil, fmt.Errorf("cache: header %q is too large for the binary codec", name)
+		}
+		size += 2 + len(name) + 2
+		for _, v := range values {
+			size += 4 + len(v)
+		}
+	}
+
+	if cap(dst)-len(dst) < size {
+		grown := make([]byte, len(dst), len(dst)+size)
+		copy(grown, dst)
+		dst = grown
+	}
+	dst = append(dst, binaryLayoutVersion)
+	dst = binary.BigEndian.AppendUint16(dst, uint16(status))
+	dst = binary.BigEndian.AppendUint64(dst, uint64(unixNano(r.Expiration)))
+	dst = binary.BigEndian.AppendUint64(dst, uint64(unixNano(r.StoredAt)))
+	dst = binary.BigEndian.AppendUint64(dst, uint64(unixNano(r.LastAccess)))
+	dst = binary.BigEndian.AppendUint64(dst, uint64(r.OriginDuration))
+	dst = binary.BigEndian.AppendUint64(dst, uint64(r.Frequency))
+	dst = appendBinaryBytes(dst, r.CanonicalKey)
+	dst = appendBinaryBytes(dst, []byte(r.URL))
+	dst = appendBinaryBytes(dst, []byte(r.Namespace))
+
+	lenAt := len(dst)
+	dst = append(dst, 0, 0, 0, 0)
+	for name, values := range r.Header {
+		if name == cacheStatusCodeHeader {
+			continue
+		}
+		dst = binary.BigEndian.AppendUint16(dst, uint16(len(name)))
+		dst = append(dst, name...)
+		dst = binary.BigEndian.AppendUint16(dst, uint16(len(values)))
+		for _, v := range values {
+			dst = binary.BigEndian.AppendUint32(dst, uint32(len(v)))
+			dst = append(dst, v...)
+		}
+	}
+	binary.BigEndian.PutUint32(dst[lenAt:], uint32(len(dst)-lenAt-4))
+
+	return appendBinaryBytes(dst, r.Value), nil
+}
+
+func appendBinaryBytes(dst, b []byte) []byte {
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(b)))
+	return append(dst, b...)
+}
+
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+// decodeBinary decodes b into r, allocating only the URL and namespace
+// strings: the header is left encoded and Value and CanonicalKey point
+// into b, until materialize.
+func decodeBinary(b []byte, r *Response) error {
+	if len(b) < binaryFixedSize {
+		return errBinaryTruncated
+	}
+	if version := b[0]; version != binaryLayoutVersion {
+		return fmt.Errorf("cache: unsupported binary layout version %d", version)
+	}
+	status := int(binary.BigEndian.Uint16(b[1:]))
+	if status < 100 {
+		return fmt.Errorf("cache: invalid status code %d in binary entry", status)
+	}
+	*r = Response{
+		status:         status,
+		Expiration:     fromUnixNano(int64(binary.BigEndian.Uint64(b[3:]))),
+		StoredAt:       fromUnixNano(int64(binary.BigEndian.Uint64(b[11:]))),
+		LastAccess:     fromUnixNano(int64(binary.BigEndian.Uint64(b[19:]))),
+		OriginDuration: time.Duration(binary.BigEndian.Uint64(b[27:])),
+		Frequency:      int(int64(binary.BigEndian.Uint64(b[35:]))),
+		lazy:           true,
+	}
+	b = b[binaryFixedSize:]
+
+	var url, namespace []byte
+	var ok bool
+	if r.CanonicalKey, b, ok = consumeBinaryBytes(b); !ok {
+		return errBinaryTruncated
+	}
+	if url, b, ok = consumeBinaryBytes(b); !ok {
+		return errBinaryTruncated
+	}
+	if namespace, b, ok = consumeBinaryBytes(b); !ok {
+		return errBinaryTruncated
+	}
+	if r.rawHeader, b, ok = consumeBinaryBytes(b); !ok || !validRawHeader(r.rawHeader) {
+		return errBinaryTruncated
+	}
+	if r.Value, b, ok = consumeBinaryBytes(b); !ok || len(b) != 0 {
+		return errBinaryTruncated
+	}
+	if len(r.CanonicalKey) == 0 {
+		r.CanonicalKey = nil
+	}
+	// The only allocations, and only for entries stored with a URL or
+	// a namespace.
+	if len(url) > 0 {
+		r.URL = string(url)
+	}
+	if len(namespace) > 0 {
+		r.Namespace = string(namespace)
+	}
+	return nil
+}
+
+func consumeBinaryBytes(b []byte) ([]byte, []byte, bool) {
+	if len(b) < 4 {
+		return nil, nil, false
+	}
+	n := binary.BigEndian.Uint32(b)
+	b = b[4:]
+	if uint64(len(b)) < uint64(n) {
+		return nil, nil, false
+	}
+	return b[:n:n], b[n:], true
+}
+
+// rangeRawHeader calls fn for each header name and its encoded values,
+// and reports whether raw was well formed.
+func rangeRawHeader(raw []byte, fn func(name []byte, count int, values []byte)) bool {
+	for len(raw) > 0 {
+		if len(raw) < 2 {
+			return false
+		}
+		n := int(binary.BigEndian.Uint16(raw))
+		if len(raw) < 2+n+2 {
+			return false
+		}
+		name := raw[2 : 2+n]
+		count := int(binary.BigEndian.Uint16(raw[2+n:]))
+		raw = raw[2+n+2:]
+		start := raw
+		for i := 0; i < count; i++ {
+			_, rest, ok := consumeBinaryBytes(raw)
+			if !ok {
+				return false
+			}
+			raw = rest
+		}
+		if fn != nil {
+			fn(name, count, start[:len(start)-len(raw)])
+		}
+	}
+	return true
+}
+
+func validRawHeader(raw []byte) bool {
+	return rangeRawHeader(raw, nil)
+}
+
+// writeRawHeader copies an encoded header into dst, as writeHeader does
+// for a decoded one.
+func writeRawHeader(dst http.Header, raw []byte) {
+	rangeRawHeader(raw, func(name []byte, count int, values []byte) {
+		vs := make([]string, 0, count)
+		for len(values) > 0 {
+			var v []byte
+			v, values, _ = consumeBinaryBytes(values)
+			vs = append(vs, string(v))
+		}
+		dst[string(name)] = vs
+	})
+}
+
+// materialize decodes the header of a lazily decoded response and copies
+// the slices it shares with the adapter, so r can be handed out.
+func (r *Response) materialize() {
+	if !r.lazy {
+		return
+	}
+	header := make(http.Header)
+	writeRawHeader(header, r.rawHeader)
+	if r.status != http.StatusOK {
+		header.Set(cacheStatusCodeHeader, strconv.Itoa(r.status))
+	}
+	r.Header = header
+	r.Value = append([]byte(nil), r.Value...)
+	if r.CanonicalKey != nil {
+		r.CanonicalKey = append([]byte(nil), r.CanonicalKey...)
+	}
+	r.rawHeader, r.status, r.lazy = nil, 0, false
+}
+
+// statusCode returns the status code the response was stored with.
+func (r Response) statusCode() int {
+	if r.lazy {
+		return r.status
+	}
+	return cachedStatusCode(r.Header)
+}
+
+// writeHeader copies the response header into dst.
+func (r Response) writeHeader(dst http.Header) {
+	if r.lazy {
+		writeRawHeader(dst, r.rawHeader)
+		return
+	}
+	writeHeader(dst, r.Header)
+}
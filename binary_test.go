@@ -0,0 +1,210 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBinaryCodecRoundTrip(t *testing.T) {
+	want := codecTestResponse()
+	want.Header = cacheHeader(want.Header, http.StatusCreated)
+	b, err := BinaryCodec{}.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Response
+	if err := (BinaryCodec{}).Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	assertSameResponse(t, got, want)
+	if got.lazy || got.statusCode() != http.StatusCreated {
+		t.Errorf("Unmarshal() = lazy %v, status %d; want a materialized 201", got.lazy, got.statusCode())
+	}
+
+	b[len(b)-1]++
+	if bytes.Equal(got.Value, b[len(b)-len(got.Value):]) {
+		t.Error("Unmarshal() Value shares memory with the input")
+	}
+}
+
+func TestBinaryDecodeRejectsUnknownLayoutVersion(t *testing.T) {
+	b, err := BinaryCodec{}.Marshal(codecTestResponse())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[0] = binaryLayoutVersion + 1
+
+	var got Response
+	if err := (BinaryCodec{}).Unmarshal(b, &got); err == nil {
+		t.Error("Unmarshal() of an unknown layout version error = nil, want error")
+	}
+}
+
+func TestBinaryDecodeIsLazy(t *testing.T) {
+	want := codecTestResponse()
+	want.URL, want.Namespace = "", ""
+	client := &Client{codec: BinaryCodec{}}
+	b, err := client.encode(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := client.decode(b); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("decode allocated %v times, want 0", allocs)
+	}
+
+	r, _ := client.decode(b)
+	if r.Header != nil || !r.lazy {
+		t.Fatal("decode parsed the header eagerly")
+	}
+	dst := http.Header{}
+	r.writeHeader(dst)
+	if dst.Get("Content-Type") != "text/plain" || len(dst["Set-Cookie"]) != 2 {
+		t.Errorf("written header = %v, want the stored header", dst)
+	}
+	if &r.Value[0] != &b[len(b)-len(r.Value)] {
+		t.Error("decode copied Value out of the adapter's slice")
+	}
+}
+
+func TestBinaryDecodeRejectsCorruptEntries(t *testing.T) {
+	b, err := BinaryCodec{}.Marshal(codecTestResponse())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n < len(b); n += 7 {
+		var r Response
+		if err := decodeBinary(b[:n], &r); err == nil {
+			t.Errorf("decoding %d of %d bytes error = nil, want error", n, len(b))
+		}
+	}
+	var r Response
+	if err := decodeBinary(append(b, 0), &r); err == nil {
+		t.Error("decoding an entry with trailing bytes error = nil, want error")
+	}
+}
+
+func TestClientWithBinaryCodec(t *testing.T) {
+	adapter := &adapterMock{store: map[uint64][]byte{}}
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(time.Minute),
+		ClientWithCodec(BinaryCodec{}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Add("Set-Cookie", "a=1")
+		w.Header().Add("Set-Cookie", "b=2")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("binary"))
+	}))
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://x/binary", nil))
+		if w.Code != http.StatusAccepted || w.Body.String() != "binary" || len(w.Header()["Set-Cookie"]) != 2 {
+			t.Fatalf("response %d = %d %q %v, want the origin response", i, w.Code, w.Body.String(), w.Header())
+		}
+		if w.Header().Get(cacheStatusCodeHeader) != "" {
+			t.Errorf("response %d leaked the status code header", i)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("origin called %d times, want 1", calls)
+	}
+
+	entry, err := client.Lookup(context.Background(), httptest.NewRequest(http.MethodGet, "http://x/binary", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.StatusCode != http.StatusAccepted || len(entry.Response.Header["Set-Cookie"]) != 2 {
+		t.Errorf("Lookup() = %d %v, want the stored status and header", entry.StatusCode, entry.Response.Header)
+	}
+	entry.Response.Value[0] = 'B'
+	if got, _ := client.Lookup(context.Background(), httptest.NewRequest(http.MethodGet, "http://x/binary", nil)); string(got.Response.Value) != "binary" {
+		t.Error("modifying the Value returned by Lookup changed the stored entry")
+	}
+}
+
+func benchmarkResponse() Response {
+	r := codecTestResponse()
+	r.Header = http.Header{
+		"Content-Type":  {"application/json"},
+		"Cache-Control": {"public, max-age=60"},
+		"Etag":          {`"5d8c72a5edda8d6a"`},
+		"Vary":          {"Accept-Encoding"},
+	}
+	r.Value = bytes.Repeat([]byte(`{"id":1,"name":"product"},`), 200)
+	return r
+}
+
+// Decoding allocates the URL and namespace strings, and nothing else.
+func TestBinaryDecodeAllocations(t *testing.T) {
+	client := &Client{codec: BinaryCodec{}}
+	r := benchmarkResponse()
+	for _, tt := range []struct {
+		url, namespace string
+		want           float64
+	}{
+		{r.URL, r.Namespace, 2},
+		{"", "", 0},
+	} {
+		r.URL, r.Namespace = tt.url, tt.namespace
+		entry, err := client.encode(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := testing.AllocsPerRun(100, func() { client.decode(entry) }); got != tt.want {
+			t.Errorf("decode with URL %q and namespace %q: %v allocations, want %v", tt.url, tt.namespace, got, tt.want)
+		}
+	}
+}
+
+func benchmarkEncode(b *testing.B, codec Codec) {
+	client := &Client{codec: codec}
+	r := benchmarkResponse()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := client.encode(r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkDecode(b *testing.B, codec Codec) {
+	client := &Client{codec: codec}
+	entry, err := client.encode(benchmarkResponse())
+	if err != nil {
+		b.Fatal(err)
+	}
+	header := http.Header{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r, err := client.decode(entry)
+		if err != nil {
+			b.Fatal(err)
+		}
+		// What a hit does with the entry.
+		r.writeHeader(header)
+		_ = r.statusCode()
+	}
+}
+
+func BenchmarkEncodeGob(b *testing.B)    { benchmarkEncode(b, GobCodec{}) }
+func BenchmarkEncodeBinary(b *testing.B) { benchmarkEncode(b, BinaryCodec{}) }
+func BenchmarkDecodeGob(b *testing.B)    { benchmarkDecode(b, GobCodec{}) }
+func BenchmarkDecodeBinary(b *testing.B) { benchmarkDecode(b, BinaryCodec{}) }
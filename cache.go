@@ -28,19 +28,22 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha256"
-	"encoding/gob"
 	"errors"
 	"fmt"
 	"hash"
 	"hash/fnv"
 	"io"
+	"math"
+	"math/rand/v2"
 	"net/http"
 	"net/url"
 	"regexp"
+	"runtime/debug"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -69,6 +72,71 @@ const (
 
 	// CacheEventPurge means a cached response was explicitly purged.
 	CacheEventPurge CacheEventType = "purge"
+
+	// CacheEventBypass means a request was served without consulting the
+	// cache, e.g. because of its method or path.
+	CacheEventBypass CacheEventType = "bypass"
+
+	// CacheEventCollision means the cached entry was stored for another
+	// request whose key collides with this one. It was released and the
+	// request served as a miss.
+	CacheEventCollision CacheEventType = "collision"
+
+	// CacheEventCorrupt means the cached entry could not be decoded. It
+	// was released and the request served as a miss.
+	CacheEventCorrupt CacheEventType = "corrupt"
+
+	// CacheEventSkip means an origin response was not stored; Reason
+	// tells why.
+	CacheEventSkip CacheEventType = "skip"
+
+	// CacheEventError means the cache could not be used for a request,
+	// which was served by the origin; Err holds the error.
+	CacheEventError CacheEventType = "error"
+
+	// CacheEventEviction means the adapter evicted an entry to make room
+	// for others. It is reported by adapters implementing
+	// AdapterNotifier and carries no request.
+	CacheEventEviction CacheEventType = "eviction"
+
+	// CacheEventCircuit means an adapter guarding its backend with a
+	// circuit breaker changed state; Reason holds the new state and Err
+	// the failure that opened the circuit. It is reported by adapters
+	// implementing AdapterNotifier and carries no request.
+	CacheEventCircuit CacheEventType = "circuit"
+)
+
+// Reasons carried by CacheEvent.Reason and reported by
+// ClientWithDebugHeaders. The first group explains a bypass or a miss,
+// the second why a response was not stored and the third the state of
+// a circuit event.
+const (
+	ReasonMethod         = "method"
+	ReasonPath           = "path"
+	ReasonRequestNoStore = "request-no-store"
+	ReasonRequestNoCache = "request-no-cache"
+	ReasonKeyError       = "key-error"
+	ReasonAdapterError   = "adapter-error"
+	ReasonCodecError     = "codec-error"
+	ReasonUnavailable    = "unavailable"
+	ReasonNotFound       = "not-found"
+	ReasonExpired        = "expired"
+	ReasonCorrupt        = "corrupt"
+	ReasonCollision      = "collision"
+	ReasonRefresh        = "refresh"
+	ReasonCoalesced      = "coalesced"
+	ReasonCapacity       = "capacity"
+	ReasonPanic          = "panic"
+
+	ReasonNotWritten   = "not-written"
+	ReasonMaxBody      = "max-body"
+	ReasonStatusCode   = "status-code"
+	ReasonCacheControl = "cache-control"
+	ReasonSkipHeader   = "skip-header"
+
+	ReasonCircuitOpen     = "circuit-open"
+	ReasonCircuitHalfOpen = "circuit-half-open"
+	ReasonCircuitClosed   = "circuit-closed"
 )
 
 // CacheEvent is passed to an observer when cache middleware events happen.
@@ -77,6 +145,66 @@ type CacheEvent struct {
 	Request    *http.Request
 	Key        uint64
 	StatusCode int
+
+	// WideKey is the WideKeyAdapter key of the entry when it is stored
+	// under a wide key (see ClientWithWideKeys). Eviction events of such
+	// entries have a zero Key.
+	WideKey string
+
+	// Reason details the event, see the Reason constants.
+	Reason string
+
+	// Age is how long ago the entry was stored, for hit and stale
+	// events.
+	Age time.Duration
+
+	// TTL is the time left until the entry expires for hit, stale (where
+	// it is negative) and store events. Zero for entries without
+	// expiration.
+	TTL time.Duration
+
+	// Size is the response body size for hit, store and skip events and
+	// the entry size for eviction events.
+	Size int
+
+	// Duration is the time the origin took to produce the response for
+	// store and skip events.
+	Duration time.Duration
+
+	// Err is the error behind error and corrupt events.
+	Err error
+}
+
+// ErrNotCached is returned by Lookup when no response is cached for the
+// request.
+var ErrNotCached = errors.New("cache: response not cached")
+
+// CacheEntry describes a cached response returned by Lookup.
+type CacheEntry struct {
+	// Response is the decoded cached response.
+	Response Response
+
+	// Key is the cache key the request maps to.
+	Key uint64
+
+	// StatusCode is the cached response status code.
+	StatusCode int
+
+	// Age is how long ago the entry was stored. Zero for entries written
+	// by older versions of this package.
+	Age time.Duration
+
+	// TTL is the time left until the entry expires, negative once it has
+	// expired. Zero for entries without expiration.
+	TTL time.Duration
+
+	// Stale reports whether the entry has expired.
+	Stale bool
+
+	// FingerprintMatch reports whether the entry was stored for this very
+	// request. False means a key collision (or a corrupted entry) that
+	// the middleware would release instead of serving.
+	FingerprintMatch bool
 }
 
 // Observer receives cache middleware events.
@@ -109,28 +237,82 @@ type Response struct {
 	// versions of this package have an empty CanonicalKey and bypass
 	// verification for backward compatibility.
 	CanonicalKey []byte
+
+	// StoredAt is when the entry was written. Refresh-ahead derives the
+	// entry's original TTL from it. Zero for entries written by older
+	// versions of this package.
+	StoredAt time.Time
+
+	// OriginDuration is how long the origin took to produce the entry.
+	// Probabilistic refresh-ahead scales its early-expiration window by
+	// it.
+	OriginDuration time.Duration
+
+	// URL is the normalized URL of the request that produced the entry.
+	// The admin handler uses it to list entries and purge by prefix.
+	// Empty for entries written by older versions of this package.
+	URL string
+
+	// Namespace is the ClientWithNamespace name of the client that
+	// stored the entry, empty without namespace.
+	Namespace string
+
+	// Entries decoded by BinaryCodec on the hit path keep their header
+	// encoded in rawHeader and their status code in status, and their
+	// Value and CanonicalKey point into the adapter's slice, until
+	// materialize is called.
+	rawHeader []byte
+	status    int
+	lazy      bool
 }
 
 // Client data structure for HTTP cache middleware.
 type Client struct {
-	adapter             Adapter
-	adapterTouch        AdapterTouch
-	ttl                 time.Duration
-	ttlSet              bool
-	refreshKey          string
-	methods             []string
-	skipCacheHeader     string
-	skipCachePathRegex  *regexp.Regexp
-	varyHeaders         []string
-	statusCodeFilter    func(int) bool
-	writeExpiresHeader  bool
-	observer            Observer
-	purgeEnabled        bool
-	maxBodySize         int
-	singleflightEnabled bool
-	respectCacheControl bool
-	staleWindow         time.Duration
-	sf                  singleflightGroup
+	adapter              Adapter
+	adapterTouch         AdapterTouch
+	ttl                  time.Duration
+	ttlSet               bool
+	refreshKey           string
+	methods              []string
+	skipCacheHeader      string
+	skipCachePathRegex   *regexp.Regexp
+	varyHeaders          []string
+	statusCodeFilter     func(int) bool
+	statusTTLs           map[int]time.Duration
+	statusClassTTLs      map[int]time.Duration
+	ttlJitterFraction    float64
+	ttlJitterDuration    time.Duration
+	minTTL               time.Duration
+	maxTTL               time.Duration
+	writeExpiresHeader   bool
+	observer             Observer
+	logger               *eventLogger
+	asyncQueueSize       int
+	asyncWorkers         int
+	asyncPolicy          DropPolicy
+	async                *asyncObserver
+	purgeEnabled         bool
+	maxBodySize          int
+	singleflightEnabled  bool
+	singleflightTimeout  time.Duration
+	respectCacheControl  bool
+	staleWindow          time.Duration
+	softPurge            bool
+	debugHeader          string
+	debugSecret          string
+	refreshAheadFraction float64
+	refreshAheadBeta     float64
+	tracer               Tracer
+	codec                Codec
+	checksum             Checksum
+	legacyEncoding       bool
+	namespace            string
+	namespaceVersion     string
+	wideKeys             bool
+	expvarName           string
+	sf                   singleflightGroup
+	refreshing           sync.Map
+	stats                clientStats
 }
 
 // ClientOption is used to set Client settings.
@@ -159,22 +341,91 @@ type AdapterTouch interface {
 	Touch(key uint64)
 }
 
+// AdapterKeys is an optional Adapter extension for adapters that can
+// enumerate their keys. AdminHandler uses it to list entries and to
+// purge them by URL prefix.
+type AdapterKeys interface {
+	Keys() []uint64
+}
+
+// AdapterFlush is an optional Adapter extension for adapters that can
+// release every entry at once.
+type AdapterFlush interface {
+	Flush()
+}
+
+// AdapterNotifier is an optional Adapter extension for adapters that
+// report events of their own, such as evictions. NewClient registers a
+// function passing them to the client observer and Stats; it may be
+// called from any goroutine, but never while the adapter holds a lock.
+type AdapterNotifier interface {
+	Notify(fn func(CacheEvent))
+}
+
+// AdapterWrapper is an optional Adapter extension for adapters wrapping
+// another one, such as the breaker, compress and encrypt adapters. The
+// client looks through wrappers for the optional interfaces that work on
+// keys rather than on stored values: AdapterTouch, AdapterTouchWide,
+// AdapterKeys, AdapterWideKeys, AdapterFlush and AdapterStats. A wrapper
+// implementing one of them itself takes precedence. With
+// ClientWithWideKeys, every adapter of the chain must implement
+// WideKeyAdapter.
+type AdapterWrapper interface {
+	Adapter
+
+	// Unwrap returns the wrapped adapter.
+	Unwrap() Adapter
+}
+
+// adapterAs returns the first adapter implementing T in the chain of
+// wrappers starting at a.
+func adapterAs[T any](a Adapter) (T, bool) {
+	for v := interface{}(a); v != nil; v = unwrapAdapter(v) {
+		if t, ok := v.(T); ok {
+			return t, true
+		}
+	}
+	var zero T
+	return zero, false
+}
+
+// unwrapAdapter returns the adapter wrapped by a, an AdapterWrapper or
+// the shim of ToAdapter, or nil.
+func unwrapAdapter(a interface{}) interface{} {
+	switch w := a.(type) {
+	case AdapterWrapper:
+		if inner := w.Unwrap(); inner != nil {
+			return inner
+		}
+	case adapterShim:
+		return w.ContextAdapter
+	}
+	return nil
+}
+
 // Middleware is the HTTP cache middleware handler.
 func (c *Client) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if c.purgeEnabled && r.Method == methodPurge && c.cacheableURIPath(r.URL) {
-			key, _, err := c.key(r)
+			key, fingerprint, err := c.key(r)
 			if err != nil {
 				next.ServeHTTP(w, r)
 				return
 			}
 
-			c.adapter.Release(key)
-			c.observe(CacheEventPurge, r, key, http.StatusNoContent)
+			wide := c.wideKey(fingerprint)
+			if err := c.purge(r.Context(), key, wide); err != nil {
+				c.adapterError(r, key, wide, err)
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			c.observe(CacheEventPurge, r, key, wide, http.StatusNoContent)
 			w.WriteHeader(http.StatusNoContent)
 			return
 		}
 
+		debug := c.debugging(r)
+
 		if c.cacheableMethod(r.Method) && c.cacheableURIPath(r.URL) {
 			// Honor request-side Cache-Control when opted in. no-store
 			// short-circuits both the lookup and the store paths; no-cache
@@ -184,6 +435,7 @@ func (c *Client) Middleware(next http.Handler) http.Handler {
 			if c.respectCacheControl {
 				reqCC = parseCacheControl(r.Header.Get("Cache-Control"))
 				if reqCC.noStore {
+					c.bypass(w, r, debug, ReasonRequestNoStore, nil)
 					next.ServeHTTP(w, r)
 					return
 				}
@@ -191,15 +443,21 @@ func (c *Client) Middleware(next http.Handler) http.Handler {
 
 			key, fingerprint, err := c.key(r)
 			if err != nil {
+				c.bypass(w, r, debug, ReasonKeyError, err)
 				next.ServeHTTP(w, r)
 				return
 			}
+			wide := c.wideKey(fingerprint)
 
 			// Refresh detection is opt-in via ClientWithRefreshKey; an empty
 			// refreshKey would otherwise match URLs containing a bare "?=x"
 			// (empty query key, non-empty value) and let any caller wipe the
 			// cache entry.
 			refreshed := false
+			missReason := ReasonNotFound
+			if reqCC.noCache {
+				missReason = ReasonRequestNoCache
+			}
 			if c.refreshKey != "" {
 				params := r.URL.Query()
 				if _, ok := params[c.refreshKey]; ok {
@@ -207,36 +465,68 @@ func (c *Client) Middleware(next http.Handler) http.Handler {
 					r.URL.RawQuery = params.Encode()
 					key, fingerprint, err = c.key(r)
 					if err != nil {
+						c.bypass(w, r, debug, ReasonKeyError, err)
 						next.ServeHTTP(w, r)
 						return
 					}
 
-					c.adapter.Release(key)
-					c.observe(CacheEventRefresh, r, key, 0)
+					wide = c.wideKey(fingerprint)
+
+					c.releaseEntry(r, key, wide)
+					c.emit(CacheEvent{Type: CacheEventRefresh, Request: r, Key: key, WideKey: wide, Reason: ReasonRefresh})
 					refreshed = true
+					missReason = ReasonRefresh
 				}
 			}
 			if !refreshed && !reqCC.noCache {
-				b, ok := c.adapter.Get(key)
+				_, lookup := c.startSpan(r.Context(), SpanLookup)
+				b, ok, getErr := c.get(r.Context(), key, wide)
+				if errors.Is(getErr, ErrAdapterUnavailable) {
+					// The adapter refuses calls, e.g. an open circuit:
+					// skip the cache altogether instead of failing
+					// again on the store.
+					lookup.End(SpanAttributes{Key: key, Event: CacheEventBypass})
+					c.bypass(w, r, debug, ReasonUnavailable, nil)
+					next.ServeHTTP(w, r)
+					return
+				}
+				if getErr != nil && canceledBy(r, getErr) {
+					// The client went away during the lookup: a miss,
+					// not a backend failure.
+					getErr = nil
+				}
 				switch {
+				case getErr != nil:
+					// The backend is failing: report it and let the
+					// origin serve the request.
+					lookup.End(SpanAttributes{Key: key, Event: CacheEventError})
+					c.adapterError(r, key, wide, getErr)
+					missReason = ReasonAdapterError
 				case !ok:
-					c.observe(CacheEventMiss, r, key, 0)
+					lookup.End(SpanAttributes{Key: key, Event: CacheEventMiss})
+					c.emit(CacheEvent{Type: CacheEventMiss, Request: r, Key: key, WideKey: wide, Reason: ReasonNotFound})
 				default:
-					response, decodeErr := decodeResponse(b)
+					response, decodeErr := c.decode(b)
 					switch {
 					case decodeErr != nil:
 						// Corrupted or version-skewed entry: drop it and
 						// fall through to the origin as a miss.
-						c.adapter.Release(key)
-						c.observe(CacheEventMiss, r, key, 0)
+						c.releaseEntry(r, key, wide)
+						lookup.End(SpanAttributes{Key: key, Event: CacheEventCorrupt})
+						c.emit(CacheEvent{Type: CacheEventCorrupt, Request: r, Key: key, WideKey: wide, Reason: ReasonCorrupt, Size: len(b), Err: decodeErr})
+						missReason = ReasonCorrupt
 					case !canonicalKeyMatches(response.CanonicalKey, fingerprint):
 						// FNV-64 collision (or corrupted entry from a
 						// different logical request): release the stored
 						// blob and serve a fresh response.
-						c.adapter.Release(key)
-						c.observe(CacheEventMiss, r, key, 0)
+						c.releaseEntry(r, key, wide)
+						lookup.End(SpanAttributes{Key: key, Event: CacheEventCollision})
+						c.emit(CacheEvent{Type: CacheEventCollision, Request: r, Key: key, WideKey: wide, Reason: ReasonCollision})
+						missReason = ReasonCollision
 					case response.Valid():
-						if c.adapterTouch != nil {
+						if t, ok := adapterAs[AdapterTouchWide](c.adapter); ok && wide != "" {
+							t.TouchWide(wide)
+						} else if c.adapterTouch != nil && wide == "" {
 							c.adapterTouch.Touch(key)
 						} else {
 							// Legacy in-blob bookkeeping for adapters that
@@ -245,11 +535,19 @@ func (c *Client) Middleware(next http.Handler) http.Handler {
 							// preserved for backward compatibility.
 							response.LastAccess = time.Now()
 							response.Frequency++
-							c.adapter.Set(key, response.Bytes(), response.Expiration)
+							if b, err := c.encode(response); err == nil {
+								if err := c.set(r.Context(), key, wide, b, response.Expiration); err != nil {
+									c.adapterError(r, key, wide, err)
+								}
+							}
 						}
 
-						statusCode := cachedStatusCode(response.Header)
-						c.observe(CacheEventHit, r, key, statusCode)
+						statusCode := response.statusCode()
+						lookup.End(SpanAttributes{Key: key, Event: CacheEventHit, TTL: remainingTTL(response.Expiration), Size: len(response.Value), StatusCode: statusCode})
+						c.emit(entryEvent(CacheEventHit, r, key, wide, response))
+						if c.refreshAhead(response, time.Now()) {
+							c.scheduleRefresh(r, next, key, fingerprint, response.Expiration)
+						}
 						// Skip the wire writes for clients that already
 						// disconnected. cachedStatusCode never returns 0
 						// (it normalizes to http.StatusOK), so an
@@ -257,143 +555,344 @@ func (c *Client) Middleware(next http.Handler) http.Handler {
 						if r.Context().Err() != nil {
 							return
 						}
-						writeHeader(w.Header(), response.Header)
+						response.writeHeader(w.Header())
 						if c.writeExpiresHeader && !response.Expiration.IsZero() {
 							w.Header().Set("Expires", response.Expiration.UTC().Format(http.TimeFormat))
 						}
+						if debug {
+							setDebugHeaders(w.Header(), debugHit, key, fingerprint, "")
+							setDebugTTL(w.Header(), response.Expiration)
+						}
 						w.WriteHeader(statusCode)
 						w.Write(response.Value)
+						c.stats.served(len(response.Value))
 						return
 					default:
 						if c.staleWindow > 0 && time.Since(response.Expiration) <= c.staleWindow {
 							// Within RFC 5861 stale-while-revalidate
 							// window: serve stale immediately and
 							// refresh the entry in the background.
-							statusCode := cachedStatusCode(response.Header)
-							c.observe(CacheEventHit, r, key, statusCode)
-							c.scheduleRefresh(r, next, key, fingerprint)
+							statusCode := response.statusCode()
+							lookup.End(SpanAttributes{Key: key, Event: CacheEventHit, TTL: remainingTTL(response.Expiration), Size: len(response.Value), StatusCode: statusCode})
+							c.emit(entryEvent(CacheEventHit, r, key, wide, response))
+							c.scheduleRefresh(r, next, key, fingerprint, response.Expiration)
 							if r.Context().Err() != nil {
 								return
 							}
-							writeHeader(w.Header(), response.Header)
+							response.writeHeader(w.Header())
 							if c.writeExpiresHeader && !response.Expiration.IsZero() {
 								w.Header().Set("Expires", response.Expiration.UTC().Format(http.TimeFormat))
 							}
+							if debug {
+								setDebugHeaders(w.Header(), debugStale, key, fingerprint, "")
+								setDebugTTL(w.Header(), response.Expiration)
+							}
 							w.WriteHeader(statusCode)
 							w.Write(response.Value)
+							c.stats.served(len(response.Value))
 							return
 						}
-						c.adapter.Release(key)
-						c.observe(CacheEventStale, r, key, 0)
+						c.releaseEntry(r, key, wide)
+						lookup.End(SpanAttributes{Key: key, Event: CacheEventStale, TTL: remainingTTL(response.Expiration)})
+						event := entryEvent(CacheEventStale, r, key, wide, response)
+						event.Reason = ReasonExpired
+						c.emit(event)
+						missReason = ReasonExpired
 					}
 				}
 			}
 
 			if c.singleflightEnabled {
-				payload, _ := c.sf.Do(strconv.FormatUint(key, 36), func() interface{} {
+				_, wait := c.startSpan(r.Context(), SpanSingleflight)
+				payload, shared, err := c.sf.Do(r.Context(), flightKey(key, wide), c.singleflightTimeout, func() interface{} {
 					cw := newCaptureWriter(c.maxBodySize)
-					next.ServeHTTP(cw, r)
+					took := c.serveOrigin(next, cw, r)
 					statusCode := cw.statusCodeValue()
-					if c.cacheableSnapshot(cw.header, cw.wrote, cw.exceeded, statusCode) {
-						now := time.Now()
-						ttl := c.responseTTL(cw.header)
-						expires := time.Time{}
-						if ttl > 0 {
-							expires = now.Add(ttl)
-						}
-						response := Response{
-							Value:        cw.body.Bytes(),
-							Header:       cacheHeader(cw.header, statusCode),
-							Expiration:   expires,
-							LastAccess:   now,
-							Frequency:    1,
-							CanonicalKey: fingerprint,
-						}
-						c.adapter.Set(key, response.Bytes(), response.Expiration)
-						c.observe(CacheEventStore, r, key, statusCode)
+					if reason := c.storeSkipReason(cw.header, cw.wrote, cw.exceeded, statusCode); reason != "" {
+						c.skipStore(r, key, wide, reason, statusCode, cw.body.Len(), took)
+					} else {
+						response := c.newResponse(r.URL.String(), cw.header, cw.body.Bytes(), statusCode, fingerprint, took)
+						c.storeResponse(r, key, response, statusCode)
 					}
 					return cw
 				})
-				cw := payload.(*captureWriter)
-				writeCapturedResponse(w, cw)
-				return
+				wait.End(SpanAttributes{Key: key, Shared: shared})
+				var pe *panicError
+				switch {
+				case errors.As(err, &pe):
+					if !shared {
+						// Report the panic with the leader's stack, then
+						// re-raise it so net/http (or any recovery
+						// middleware) sees the original value.
+						c.emit(CacheEvent{Type: CacheEventError, Request: r, Key: key, WideKey: wide, Reason: ReasonPanic, Err: pe})
+						panic(pe.value)
+					}
+					w.WriteHeader(http.StatusBadGateway)
+					return
+				case errors.Is(err, errSingleflightTimeout):
+					// The leader is taking too long: fall back to the
+					// origin below.
+				case err != nil:
+					// The caller went away while waiting.
+					return
+				default:
+					// A background refresh that skipped the origin
+					// shares no response; fall back to the origin below.
+					if cw, ok := payload.(*captureWriter); ok {
+						if debug {
+							reason := missReason
+							if shared {
+								reason = ReasonCoalesced
+							}
+							setDebugHeaders(w.Header(), debugMiss, key, fingerprint, reason)
+							setDebugSkipped(w.Header(), c.storeSkipReason(cw.header, cw.wrote, cw.exceeded, cw.statusCodeValue()))
+						}
+						writeCapturedResponse(w, cw)
+						return
+					}
+				}
 			}
 
 			rw := newResponseWriter(w, c.maxBodySize)
-			next.ServeHTTP(rw, r)
+			if debug {
+				// The store decision is only final once the handler
+				// returns, but headers leave with the first write: report
+				// what is known by then and send a max-body overflow that
+				// happens later as a trailer.
+				rw.onHeader = func(h http.Header, statusCode int) {
+					setDebugHeaders(h, debugMiss, key, fingerprint, missReason)
+					setDebugSkipped(h, c.storeSkipReason(rw.header, true, rw.exceeded, statusCode))
+				}
+			}
+			took := c.serveOrigin(next, rw, r)
+			if debug && rw.exceeded && w.Header().Get(debugSkipHeader) == "" {
+				w.Header().Set(http.TrailerPrefix+debugSkipHeader, ReasonMaxBody)
+			}
 
 			statusCode := rw.statusCodeValue()
-			value := rw.body.Bytes()
-			now := time.Now()
-			ttl := c.responseTTL(rw.Header())
-			expires := time.Time{}
-			if ttl > 0 {
-				expires = now.Add(ttl)
-			}
-			if c.cacheableResponse(rw, statusCode) {
-				response := Response{
-					Value:        value,
-					Header:       cacheHeader(rw.Header(), statusCode),
-					Expiration:   expires,
-					LastAccess:   now,
-					Frequency:    1,
-					CanonicalKey: fingerprint,
-				}
-				c.adapter.Set(key, response.Bytes(), response.Expiration)
-				c.observe(CacheEventStore, r, key, statusCode)
+			if reason := c.storeSkipReason(rw.Header(), rw.wrote, rw.exceeded, statusCode); reason != "" {
+				c.skipStore(r, key, wide, reason, statusCode, rw.body.Len(), took)
+			} else {
+				response := c.newResponse(r.URL.String(), rw.Header(), rw.body.Bytes(), statusCode, fingerprint, took)
+				c.storeResponse(r, key, response, statusCode)
 			}
 
 			return
 		}
 
+		if c.cacheableMethod(r.Method) {
+			c.bypass(w, r, debug, ReasonPath, nil)
+		} else {
+			c.bypass(w, r, debug, ReasonMethod, nil)
+		}
 		next.ServeHTTP(w, r)
 	})
 }
 
-// scheduleRefresh kicks off a background revalidation of a stale entry.
-// The work is coalesced through the same singleflight group used by
-// ClientWithSingleflight, so a stampede of concurrent stale hits runs
-// exactly one origin request. The refresh outlives the caller's
-// context: it is given context.Background() so a disconnect on the
-// triggering request does not abort the refill.
-func (c *Client) scheduleRefresh(r *http.Request, next http.Handler, key uint64, fingerprint []byte) {
-	cloned := r.Clone(context.Background())
-	go c.sf.Do(strconv.FormatUint(key, 36), func() interface{} {
-		if b, ok := c.adapter.Get(key); ok {
-			if resp, err := decodeResponse(b); err == nil && resp.Valid() {
-				return nil
+// scheduleRefresh kicks off a background revalidation of a stale (or,
+// with refresh-ahead, soon to expire) entry. The work is coalesced
+// through the same singleflight group used by ClientWithSingleflight,
+// so a stampede of concurrent hits runs exactly one origin request; seen
+// is the expiration of the entry that triggered the refresh, and a
+// refresh that finds the entry already rewritten is skipped. Hits arriving
+// while a refresh of the entry is in flight return at once, without
+// cloning the request or starting a goroutine. The refresh outlives the
+// caller's context: it keeps the request context values (and, with
+// ClientWithTracer, a link to the request span) but not its
+// cancellation, so a disconnect on the triggering request does not abort
+// the refill.
+func (c *Client) scheduleRefresh(r *http.Request, next http.Handler, key uint64, fingerprint []byte, seen time.Time) {
+	wide := c.wideKey(fingerprint)
+	inFlight := flightKey(key, wide)
+	if _, loaded := c.refreshing.LoadOrStore(inFlight, struct{}{}); loaded {
+		return
+	}
+	ctx, span := c.startLinkedSpan(context.WithoutCancel(r.Context()), SpanRefresh, r.Context())
+	cloned := r.Clone(ctx)
+	go func() {
+		defer c.refreshing.Delete(inFlight)
+		attrs := SpanAttributes{Key: key}
+		_, shared, err := c.sf.Do(ctx, inFlight, 0, func() interface{} {
+			if b, ok, _ := c.get(ctx, key, wide); ok {
+				if resp, err := c.decode(b); err == nil && !resp.Expiration.Equal(seen) {
+					return nil
+				}
 			}
+			cw := newCaptureWriter(c.maxBodySize)
+			took := c.serveOrigin(next, cw, cloned)
+			statusCode := cw.statusCodeValue()
+			attrs.StatusCode = statusCode
+			if reason := c.storeSkipReason(cw.header, cw.wrote, cw.exceeded, statusCode); reason != "" {
+				c.skipStore(cloned, key, wide, reason, statusCode, cw.body.Len(), took)
+				return cw
+			}
+			response := c.newResponse(cloned.URL.String(), cw.header, cw.body.Bytes(), statusCode, fingerprint, took)
+			c.storeResponse(cloned, key, response, statusCode)
+			attrs.Event = CacheEventStore
+			attrs.TTL = remainingTTL(response.Expiration)
+			attrs.Size = len(response.Value)
+			return cw
+		})
+		attrs.Shared = shared
+		var pe *panicError
+		if errors.As(err, &pe) && !shared {
+			// Nobody is left to re-raise the panic of a background
+			// refresh: report it.
+			attrs.Event = CacheEventError
+			c.emit(CacheEvent{Type: CacheEventError, Request: cloned, Key: key, WideKey: wide, Reason: ReasonPanic, Err: pe})
 		}
-		cw := newCaptureWriter(c.maxBodySize)
-		next.ServeHTTP(cw, cloned)
-		statusCode := cw.statusCodeValue()
-		if !c.cacheableSnapshot(cw.header, cw.wrote, cw.exceeded, statusCode) {
-			return nil
-		}
-		now := time.Now()
-		ttl := c.responseTTL(cw.header)
-		expires := time.Time{}
-		if ttl > 0 {
-			expires = now.Add(ttl)
-		}
-		response := Response{
-			Value:        cw.body.Bytes(),
-			Header:       cacheHeader(cw.header, statusCode),
-			Expiration:   expires,
-			LastAccess:   now,
-			Frequency:    1,
-			CanonicalKey: fingerprint,
-		}
-		c.adapter.Set(key, response.Bytes(), response.Expiration)
-		c.observe(CacheEventStore, cloned, key, statusCode)
-		return nil
-	})
+		span.End(attrs)
+	}()
+}
+
+// flightKey returns the key coalescing the origin requests for an
+// entry: its wide key when it has one, so two requests whose uint64 keys
+// collide are not served each other's response.
+func flightKey(key uint64, wide string) string {
+	if wide != "" {
+		return wide
+	}
+	return strconv.FormatUint(key, 36)
 }
 
 // Drop releases the cache entry matching the given request. The caller's
 // *http.Request is left unmodified: its URL.RawQuery is not reordered
 // and its Body remains readable after the call returns.
 func (c *Client) Drop(r *http.Request) error {
+	key, fingerprint, err := c.requestKey(r)
+	if err != nil {
+		return err
+	}
+	return c.purge(r.Context(), key, c.wideKey(fingerprint))
+}
+
+// Lookup returns the entry cached for r, using the same cache key rules
+// as the middleware. It is meant for admin tools, tests and debugging:
+// the entry is returned as stored, whether it is still valid or not, and
+// no access is recorded, so eviction counters are left untouched. The
+// caller's *http.Request is left unmodified. Lookup returns ErrNotCached
+// when there is no entry and the decoding error for a corrupted one.
+func (c *Client) Lookup(ctx context.Context, r *http.Request) (CacheEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return CacheEntry{}, err
+	}
+	key, fingerprint, err := c.requestKey(r)
+	if err != nil {
+		return CacheEntry{}, err
+	}
+	b, ok, err := c.get(ctx, key, c.wideKey(fingerprint))
+	if err != nil {
+		return CacheEntry{}, err
+	}
+	if !ok {
+		return CacheEntry{}, ErrNotCached
+	}
+	response, err := c.decode(b)
+	if err != nil {
+		return CacheEntry{}, err
+	}
+	response.materialize()
+
+	now := time.Now()
+	entry := CacheEntry{
+		Response:         response,
+		Key:              key,
+		StatusCode:       response.statusCode(),
+		Stale:            !response.Valid(),
+		FingerprintMatch: canonicalKeyMatches(response.CanonicalKey, fingerprint),
+	}
+	if !response.StoredAt.IsZero() {
+		entry.Age = now.Sub(response.StoredAt)
+	}
+	if !response.Expiration.IsZero() {
+		entry.TTL = response.Expiration.Sub(now)
+	}
+	return entry, nil
+}
+
+// Store writes an entry for r as if the middleware had served it with
+// the given status, header and body: the same key, fingerprint and
+// header rules apply, so a later request through the middleware hits it.
+// A zero ttl applies the client's TTL rules (ClientWithTTL, per-status
+// TTLs, Cache-Control, jitter and bounds); a positive ttl is used as is.
+// Store does not consult the status code filter or the skip rules, since
+// the caller asks for this entry explicitly.
+func (c *Client) Store(ctx context.Context, r *http.Request, statusCode int, header http.Header, body []byte, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if statusCode < 100 || statusCode > 999 {
+		return fmt.Errorf("cache: invalid status code %d", statusCode)
+	}
+	if ttl < 0 {
+		return fmt.Errorf("cache: ttl %v is invalid", ttl)
+	}
+	key, fingerprint, err := c.requestKey(r)
+	if err != nil {
+		return err
+	}
+	if header == nil {
+		header = http.Header{}
+	}
+
+	u := *r.URL
+	sortURLParams(&u)
+	response := c.newResponse(u.String(), header, body, statusCode, fingerprint, 0)
+	if ttl > 0 {
+		response.Expiration = response.StoredAt.Add(ttl)
+	}
+	return c.storeResponse(r, key, response, statusCode)
+}
+
+// Warm runs requests through the middleware wrapping handler, at most
+// concurrency at a time, so entries are cached before real traffic
+// arrives. Responses are discarded and requests that hit the cache do not
+// reach handler. Warm blocks until every request has been served or ctx
+// is done, in which case it returns ctx.Err(); run it in its own
+// goroutine to warm in the background.
+func (c *Client) Warm(ctx context.Context, handler http.Handler, requests []*http.Request, concurrency int) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	mw := c.Middleware(handler)
+	jobs := make(chan *http.Request)
+
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			for r := range jobs {
+				mw.ServeHTTP(discardWriter{header: http.Header{}}, r.WithContext(ctx))
+			}
+		}()
+	}
+
+feed:
+	for _, r := range requests {
+		select {
+		case jobs <- r:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return ctx.Err()
+}
+
+// discardWriter is the http.ResponseWriter Warm serves requests to.
+type discardWriter struct {
+	header http.Header
+}
+
+func (w discardWriter) Header() http.Header         { return w.header }
+func (w discardWriter) WriteHeader(int)             {}
+func (w discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+
+// requestKey computes the cache key and fingerprint of r like the
+// middleware does, without mutating the caller's request.
+func (c *Client) requestKey(r *http.Request) (uint64, []byte, error) {
 	// Read the body up front so the caller gets a fresh reader and we
 	// can hand a separate, equivalent copy to c.key.
 	var bodyBytes []byte
@@ -401,7 +900,7 @@ func (c *Client) Drop(r *http.Request) error {
 		b, err := io.ReadAll(r.Body)
 		r.Body.Close()
 		if err != nil {
-			return err
+			return 0, nil, err
 		}
 		bodyBytes = b
 		r.Body = io.NopCloser(bytes.NewBuffer(b))
@@ -416,58 +915,300 @@ func (c *Client) Drop(r *http.Request) error {
 		cloned.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
 	}
 
-	key, _, err := c.key(&cloned)
+	return c.key(&cloned)
+}
+
+// purge invalidates the entry stored under key, or under the wide key
+// when it is set. By default the entry is released. With
+// ClientWithSoftPurge and a stale window it is kept and its expiration is
+// rewritten to the past instead, so the stale-while-revalidate and
+// singleflight paths take over: the old copy keeps being served while a
+// single request refreshes it from the origin.
+func (c *Client) purge(ctx context.Context, key uint64, wide string) error {
+	if !c.softPurge || c.staleWindow <= 0 {
+		// Without a stale window a soft-purged entry could never be
+		// served again: keeping it would only waste the space.
+		return c.release(ctx, key, wide)
+	}
+
+	b, ok, err := c.get(ctx, key, wide)
+	if err != nil || !ok {
+		return err
+	}
+	response, err := c.decode(b)
+	if err != nil {
+		return c.release(ctx, key, wide)
+	}
+	if !response.Valid() {
+		// Already stale; rewriting it would only push the entry further
+		// out of the stale window.
+		return nil
+	}
+
+	now := time.Now()
+	response.Expiration = now
+	// The adapter has to keep the entry around for the stale window,
+	// otherwise a TTL-aware backend (Redis) would drop it right away and
+	// the purge would be as hard as a Release.
+	b, err = c.encode(response)
 	if err != nil {
 		return err
 	}
-	c.adapter.Release(key)
+	return c.set(ctx, key, wide, b, now.Add(c.staleWindow))
+}
+
+// newResponse builds the entry stored for a handler's output to a
+// request for url. took is how long the origin needed to produce it.
+func (c *Client) newResponse(url string, header http.Header, value []byte, statusCode int, fingerprint []byte, took time.Duration) Response {
+	now := time.Now()
+	expires := time.Time{}
+	if ttl := c.responseTTL(header, statusCode); ttl > 0 {
+		expires = now.Add(ttl)
+	} else if c.respectCacheControl {
+		// An origin max-age=0 expires the entry at once rather than
+		// never; the middleware does not store it at all.
+		if ttl, ok := parseCacheControl(header.Get("Cache-Control")).ttl(); ok && ttl <= 0 {
+			expires = now
+		}
+	}
+	return Response{
+		Value:          value,
+		Header:         cacheHeader(header, statusCode),
+		Expiration:     expires,
+		LastAccess:     now,
+		Frequency:      1,
+		CanonicalKey:   fingerprint,
+		StoredAt:       now,
+		OriginDuration: took,
+		URL:            url,
+		Namespace:      c.namespace,
+	}
+}
+
+// storeResponse writes response to the adapter and records the store. The
+// write outlives r's cancellation: a response the origin completed is
+// worth keeping even if the client went away.
+func (c *Client) storeResponse(r *http.Request, key uint64, response Response, statusCode int) error {
+	_, span := c.startSpan(r.Context(), SpanStore)
+	wide := c.wideKey(response.CanonicalKey)
+	b, err := c.encode(response)
+	if err != nil {
+		span.End(SpanAttributes{Key: key, Event: CacheEventError})
+		c.emit(CacheEvent{Type: CacheEventError, Request: r, Key: key, WideKey: wide, Reason: ReasonCodecError, Err: err})
+		return err
+	}
+	if err := c.set(context.WithoutCancel(r.Context()), key, wide, b, response.Expiration); err != nil {
+		span.End(SpanAttributes{Key: key, Event: CacheEventError})
+		c.adapterError(r, key, wide, err)
+		return err
+	}
+	span.End(SpanAttributes{
+		Key:        key,
+		Event:      CacheEventStore,
+		TTL:        remainingTTL(response.Expiration),
+		Size:       len(response.Value),
+		StatusCode: statusCode,
+	})
+	c.stats.stored(len(b))
+	c.emit(CacheEvent{
+		Type:       CacheEventStore,
+		Request:    r,
+		Key:        key,
+		WideKey:    wide,
+		StatusCode: statusCode,
+		TTL:        remainingTTL(response.Expiration),
+		Size:       len(response.Value),
+		Duration:   response.OriginDuration,
+	})
 	return nil
 }
 
-func (c *Client) cacheableResponse(rw *responseWriter, statusCode int) bool {
-	return c.cacheableSnapshot(rw.Header(), rw.wrote, rw.exceeded, statusCode)
+// skipStore records an origin response that was not stored.
+func (c *Client) skipStore(r *http.Request, key uint64, wide, reason string, statusCode, size int, took time.Duration) {
+	c.emit(CacheEvent{
+		Type:       CacheEventSkip,
+		Request:    r,
+		Key:        key,
+		WideKey:    wide,
+		StatusCode: statusCode,
+		Reason:     reason,
+		Size:       size,
+		Duration:   took,
+	})
+}
+
+// entryEvent returns an event describing the cached response served (or
+// released) for r.
+func entryEvent(eventType CacheEventType, r *http.Request, key uint64, wide string, response Response) CacheEvent {
+	event := CacheEvent{
+		Type:       eventType,
+		Request:    r,
+		Key:        key,
+		WideKey:    wide,
+		StatusCode: response.statusCode(),
+		TTL:        remainingTTL(response.Expiration),
+		Size:       len(response.Value),
+	}
+	if !response.StoredAt.IsZero() {
+		event.Age = time.Since(response.StoredAt)
+	}
+	return event
+}
+
+// serveOrigin runs next for r, inside an origin span when tracing, and
+// returns how long it took.
+func (c *Client) serveOrigin(next http.Handler, w statusWriter, r *http.Request) time.Duration {
+	ctx, span := c.startSpan(r.Context(), SpanOrigin)
+	if c.tracer != nil {
+		r = r.WithContext(ctx)
+	}
+	start := time.Now()
+	next.ServeHTTP(w, r)
+	took := time.Since(start)
+	span.End(SpanAttributes{StatusCode: w.statusCodeValue()})
+	c.stats.origin(took)
+	return took
+}
+
+// statusWriter is an http.ResponseWriter recording the status code
+// written by the handler.
+type statusWriter interface {
+	http.ResponseWriter
+	statusCodeValue() int
+}
+
+// refreshAhead reports whether a still valid entry should be refreshed
+// in the background before it expires. The fixed mode triggers once
+// less than refreshAheadFraction of the entry's TTL remains; the
+// probabilistic mode implements XFetch (Vattani et al., "Optimal
+// Probabilistic Cache Stampede Prevention"), triggering with a
+// probability that grows as expiration approaches and scales with how
+// expensive the origin was. Entries that do not record the origin
+// duration, written by Client.Store or by older versions, fall back to
+// the fixed mode, with xfetchFallbackFraction unless ClientWithRefreshAhead
+// sets a fraction.
+func (c *Client) refreshAhead(response Response, now time.Time) bool {
+	if response.Expiration.IsZero() {
+		return false
+	}
+	remaining := response.Expiration.Sub(now)
+	fraction := c.refreshAheadFraction
+	if c.refreshAheadBeta > 0 {
+		if response.OriginDuration > 0 {
+			// 1-Float64 lies in (0, 1], so the logarithm is finite.
+			early := -float64(response.OriginDuration) * c.refreshAheadBeta * math.Log(1-rand.Float64())
+			if float64(remaining) <= early {
+				return true
+			}
+		} else if fraction == 0 {
+			fraction = xfetchFallbackFraction
+		}
+	}
+	if fraction > 0 && !response.StoredAt.IsZero() {
+		ttl := response.Expiration.Sub(response.StoredAt)
+		if float64(remaining) < float64(ttl)*fraction {
+			return true
+		}
+	}
+	return false
 }
 
-func (c *Client) cacheableSnapshot(header http.Header, wrote, exceeded bool, statusCode int) bool {
+// xfetchFallbackFraction is the refresh-ahead fraction of entries the
+// probabilistic mode cannot weigh, since they do not record how long the
+// origin took.
+const xfetchFallbackFraction = 0.1
+
+// storeSkipReason returns why a handler's response must not be stored,
+// or "" when it can be.
+func (c *Client) storeSkipReason(header http.Header, wrote, exceeded bool, statusCode int) string {
 	if !wrote {
 		// Handlers that return without calling Write or WriteHeader
 		// (early error returns, hijacked connections, abandoned RPCs)
 		// would otherwise be cached as an empty 200 OK response and
 		// served back to every subsequent request.
-		return false
+		return ReasonNotWritten
 	}
 	if exceeded {
-		return false
+		return ReasonMaxBody
 	}
-	if !c.statusCodeFilter(statusCode) {
-		return false
+	if _, ok := c.statusTTL(statusCode); !ok && !c.statusCodeFilter(statusCode) {
+		return ReasonStatusCode
 	}
 	if c.respectCacheControl {
 		cc := parseCacheControl(header.Get("Cache-Control"))
 		if cc.noStore || cc.noCache || cc.private {
-			return false
+			return ReasonCacheControl
+		}
+		// max-age=0 without a floor: the entry would be stale at once.
+		if ttl, ok := cc.ttl(); ok && ttl <= 0 && c.minTTL == 0 {
+			return ReasonCacheControl
 		}
 	}
-	if c.skipCacheHeader == "" {
-		return true
+	if c.skipCacheHeader != "" && header.Get(c.skipCacheHeader) != "" {
+		return ReasonSkipHeader
 	}
-	return header.Get(c.skipCacheHeader) == ""
+	return ""
 }
 
 // responseTTL returns the duration the middleware should keep this
 // response cached. When ClientWithRespectCacheControl is enabled the
-// response's s-maxage / max-age override the client default.
-func (c *Client) responseTTL(header http.Header) time.Duration {
+// response's s-maxage / max-age override everything else; otherwise a
+// ClientWithStatusCodeTTLs entry for the status code (or its class)
+// overrides the client default.
+func (c *Client) responseTTL(header http.Header, statusCode int) time.Duration {
 	if c.respectCacheControl {
-		cc := parseCacheControl(header.Get("Cache-Control"))
-		if cc.hasSMaxAge {
-			return cc.sMaxAge
+		if ttl, ok := parseCacheControl(header.Get("Cache-Control")).ttl(); ok {
+			return c.adjustTTL(ttl, true)
 		}
-		if cc.hasMaxAge {
-			return cc.maxAge
+	}
+	if ttl, ok := c.statusTTL(statusCode); ok {
+		return c.adjustTTL(ttl, false)
+	}
+	return c.adjustTTL(c.ttl, false)
+}
+
+// adjustTTL applies the ClientWithTTLBounds clamps and then
+// ClientWithTTLJitter / ClientWithTTLJitterDuration, keeping the jittered
+// TTL within the bounds: a TTL at the ceiling is spread below it. A zero
+// TTL from the client configuration means "no expiration", so only the
+// ceiling applies to it; a zero max-age sent by the origin is a real zero
+// and is raised to the floor. Without a floor it stays zero, and the
+// response is not stored (see storeSkipReason).
+func (c *Client) adjustTTL(ttl time.Duration, fromOrigin bool) time.Duration {
+	if ttl == 0 && !fromOrigin {
+		if c.maxTTL == 0 {
+			return 0
 		}
+		ttl = c.maxTTL
 	}
-	return c.ttl
+	if ttl < c.minTTL {
+		ttl = c.minTTL
+	}
+	if c.maxTTL > 0 && ttl > c.maxTTL {
+		ttl = c.maxTTL
+	}
+	if ttl <= 0 {
+		return ttl
+	}
+	span := time.Duration(float64(ttl)*c.ttlJitterFraction) + c.ttlJitterDuration
+	if span <= 0 {
+		return ttl
+	}
+	lo, hi := ttl, ttl+span
+	if c.maxTTL > 0 && hi > c.maxTTL {
+		lo, hi = max(c.maxTTL-span, c.minTTL, 1), c.maxTTL
+	}
+	return lo + rand.N(hi-lo+1)
+}
+
+// statusTTL returns the ClientWithStatusCodeTTLs entry for statusCode.
+// An exact status code wins over its class.
+func (c *Client) statusTTL(statusCode int) (time.Duration, bool) {
+	if ttl, ok := c.statusTTLs[statusCode]; ok {
+		return ttl, true
+	}
+	ttl, ok := c.statusClassTTLs[statusCode/100]
+	return ttl, ok
 }
 
 // cacheControl is a tiny subset of RFC 7234 directives recognized by
@@ -483,6 +1224,15 @@ type cacheControl struct {
 	hasSMaxAge bool
 }
 
+// ttl returns the lifetime set by s-maxage, else by max-age, and whether
+// either is present.
+func (cc cacheControl) ttl() (time.Duration, bool) {
+	if cc.hasSMaxAge {
+		return cc.sMaxAge, true
+	}
+	return cc.maxAge, cc.hasMaxAge
+}
+
 func parseCacheControl(h string) cacheControl {
 	var cc cacheControl
 	if h == "" {
@@ -547,9 +1297,11 @@ func (c *Client) key(r *http.Request) (uint64, []byte, error) {
 		(r.Method == http.MethodPost || r.Method == methodPurge)
 	if !bodyKeyed {
 		urlStr := r.URL.String()
-		return generateKeyWithHeaders(urlStr, r.Header, c.varyHeaders),
+		key, fingerprint := c.namespaced(
+			generateKeyWithHeaders(urlStr, r.Header, c.varyHeaders),
 			canonicalFingerprint(urlStr, nil, r.Header, c.varyHeaders),
-			nil
+		)
+		return key, fingerprint, nil
 	}
 
 	body, err := io.ReadAll(r.Body)
@@ -560,9 +1312,11 @@ func (c *Client) key(r *http.Request) (uint64, []byte, error) {
 
 	r.Body = io.NopCloser(bytes.NewBuffer(body))
 	urlStr := r.URL.String()
-	return generateKeyWithBodyAndHeaders(urlStr, body, r.Header, c.varyHeaders),
+	key, fingerprint := c.namespaced(
+		generateKeyWithBodyAndHeaders(urlStr, body, r.Header, c.varyHeaders),
 		canonicalFingerprint(urlStr, body, r.Header, c.varyHeaders),
-		nil
+	)
+	return key, fingerprint, nil
 }
 
 // canonicalKeyMatches returns true when the stored canonical key matches
@@ -610,19 +1364,26 @@ func canonicalFingerprint(URL string, body []byte, headers http.Header, varyHead
 	return h.Sum(nil)
 }
 
-func (c *Client) observe(eventType CacheEventType, r *http.Request, key uint64, statusCode int) {
-	if c.observer == nil {
-		return
-	}
-
-	c.observer(CacheEvent{
+func (c *Client) observe(eventType CacheEventType, r *http.Request, key uint64, wide string, statusCode int) {
+	c.emit(CacheEvent{
 		Type:       eventType,
 		Request:    r,
 		Key:        key,
+		WideKey:    wide,
 		StatusCode: statusCode,
 	})
 }
 
+func (c *Client) emit(event CacheEvent) {
+	c.stats.event(event.Type)
+	switch {
+	case c.async != nil:
+		c.async.send(event)
+	case c.observer != nil:
+		c.observer(event)
+	}
+}
+
 func cacheHeader(header http.Header, statusCode int) http.Header {
 	cachedHeader := cloneHeader(header)
 	cachedHeader.Del(cacheStatusCodeHeader)
@@ -665,40 +1426,27 @@ func writeHeader(dst http.Header, src http.Header) {
 }
 
 // BytesToResponse converts bytes array into Response data structure.
-// Decoding errors are silently swallowed for backward compatibility;
-// the middleware uses decodeResponse internally so it can detect
-// corruption and treat the entry as a cache miss.
+// It reads entries written with gob, JSON or BinaryCodec. Decoding errors
+// are silently swallowed for backward compatibility; the middleware uses
+// decodeResponse internally so it can detect corruption and treat the
+// entry as a cache miss. Entries written with other codecs need
+// Client.BytesToResponse.
 func BytesToResponse(b []byte) Response {
-	r, _ := decodeResponse(b)
+	r, _ := decodeResponse(nil, b)
+	r.materialize()
 	return r
 }
 
-// decodeResponse returns a Response and any error from the gob decoder
-// so callers can distinguish empty/corrupt entries from zero-valued ones.
-func decodeResponse(b []byte) (Response, error) {
-	var r Response
-	if len(b) == 0 {
-		return r, errors.New("cache: empty response payload")
-	}
-	dec := gob.NewDecoder(bytes.NewReader(b))
-	if err := dec.Decode(&r); err != nil {
-		return Response{}, err
-	}
-	return r, nil
-}
-
 // Valid returns whether the response can still be served from cache.
 func (r Response) Valid() bool {
 	return r.Expiration.IsZero() || r.Expiration.After(time.Now())
 }
 
-// Bytes converts Response data structure into bytes array.
+// Bytes converts Response data structure into bytes array, encoded with
+// GobCodec.
 func (r Response) Bytes() []byte {
-	var b bytes.Buffer
-	enc := gob.NewEncoder(&b)
-	enc.Encode(&r)
-
-	return b.Bytes()
+	b, _ := encodeResponse(GobCodec{}, r)
+	return b
 }
 
 func sortURLParams(URL *url.URL) {
@@ -788,6 +1536,17 @@ func NewClient(opts ...ClientOption) (*Client, error) {
 	if !c.ttlSet {
 		return nil, errors.New("cache client ttl is not set")
 	}
+	if c.wideKeys && !takesWideKeys(c.adapter) {
+		return nil, errors.New("cache client wide keys require a WideKeyAdapter")
+	}
+	if c.legacyEncoding {
+		if _, ok := c.codec.(GobCodec); c.codec != nil && !ok {
+			return nil, errors.New("cache client legacy encoding requires GobCodec")
+		}
+		if c.checksum != 0 {
+			return nil, errors.New("cache client legacy encoding does not take a checksum")
+		}
+	}
 	if c.methods == nil {
 		c.methods = []string{http.MethodGet}
 	}
@@ -796,6 +1555,31 @@ func NewClient(opts ...ClientOption) (*Client, error) {
 			return statusCode < 400
 		}
 	}
+	if c.logger != nil {
+		if observer := c.observer; observer != nil {
+			c.observer = func(event CacheEvent) {
+				observer(event)
+				c.logger.log(event)
+			}
+		} else {
+			c.observer = c.logger.log
+		}
+	}
+	if c.asyncQueueSize > 0 && c.observer == nil {
+		return nil, errors.New("cache client async observer requires an observer or a logger")
+	}
+	if c.expvarName != "" {
+		// After every check: a published name can never be taken back.
+		if err := c.publishExpvar(); err != nil {
+			return nil, err
+		}
+	}
+	if c.asyncQueueSize > 0 {
+		c.async = newAsyncObserver(c.observer, c.asyncQueueSize, c.asyncWorkers, c.asyncPolicy)
+	}
+	if n, ok := adapterAs[AdapterNotifier](c.adapter); ok {
+		n.Notify(c.emit)
+	}
 
 	return c, nil
 }
@@ -805,7 +1589,7 @@ func NewClient(opts ...ClientOption) (*Client, error) {
 func ClientWithAdapter(a Adapter) ClientOption {
 	return func(c *Client) error {
 		c.adapter = a
-		if t, ok := a.(AdapterTouch); ok {
+		if t, ok := adapterAs[AdapterTouch](a); ok {
 			c.adapterTouch = t
 		} else {
 			c.adapterTouch = nil
@@ -828,6 +1612,48 @@ func ClientWithTTL(ttl time.Duration) ClientOption {
 	}
 }
 
+// ClientWithTTLJitter extends every TTL by a random amount of up to
+// fraction of it (0.1 adds 0-10%), so entries warmed together do not
+// all expire in the same second. Optional setting.
+func ClientWithTTLJitter(fraction float64) ClientOption {
+	return func(c *Client) error {
+		if fraction < 0 || fraction > 1 {
+			return fmt.Errorf("cache client ttl jitter %v must be in [0, 1]", fraction)
+		}
+		c.ttlJitterFraction = fraction
+		return nil
+	}
+}
+
+// ClientWithTTLJitterDuration extends every TTL by a random amount of up
+// to jitter. It adds up with ClientWithTTLJitter when both are set.
+// Optional setting.
+func ClientWithTTLJitterDuration(jitter time.Duration) ClientOption {
+	return func(c *Client) error {
+		if jitter < 0 {
+			return fmt.Errorf("cache client ttl jitter %v is invalid", jitter)
+		}
+		c.ttlJitterDuration = jitter
+		return nil
+	}
+}
+
+// ClientWithTTLBounds clamps every TTL, including the ones taken from
+// Cache-Control and ClientWithStatusCodeTTLs, to [min, max]; jitter is
+// then applied within the bounds. A zero bound is ignored. With a max
+// set, entries that would otherwise never expire are kept for max
+// instead. Optional setting.
+func ClientWithTTLBounds(min, max time.Duration) ClientOption {
+	return func(c *Client) error {
+		if min < 0 || max < 0 || (max > 0 && min > max) {
+			return fmt.Errorf("cache client ttl bounds [%v, %v] are invalid", min, max)
+		}
+		c.minTTL = min
+		c.maxTTL = max
+		return nil
+	}
+}
+
 // ClientWithRefreshKey sets the parameter key used to free a request
 // cached response. Optional setting.
 func ClientWithRefreshKey(refreshKey string) ClientOption {
@@ -863,6 +1689,46 @@ func ClientWithStatusCodeFilter(filter func(int) bool) ClientOption {
 	}
 }
 
+// ClientWithStatusCodeTTLs sets per-status TTLs, overriding the client
+// TTL for matching responses. Keys are either a status code ("404") or a
+// status class ("4xx"); an exact code wins over its class. Responses
+// with a configured TTL are cached even when ClientWithStatusCodeFilter
+// would reject them, which enables negative caching:
+//
+//	cache.ClientWithStatusCodeTTLs(map[string]time.Duration{
+//		"200": time.Hour,
+//		"301": 24 * time.Hour,
+//		"404": 30 * time.Second,
+//		"410": 30 * time.Second,
+//	})
+//
+// A TTL of 0 caches the response without expiration, as with
+// ClientWithTTL. Cache-Control max-age / s-maxage still take precedence
+// when ClientWithRespectCacheControl is enabled. Optional setting.
+func ClientWithStatusCodeTTLs(ttls map[string]time.Duration) ClientOption {
+	return func(c *Client) error {
+		codes := make(map[int]time.Duration)
+		classes := make(map[int]time.Duration)
+		for k, ttl := range ttls {
+			if ttl < 0 {
+				return fmt.Errorf("cache client ttl %v for status %q is invalid", ttl, k)
+			}
+			if len(k) == 3 && k[0] >= '1' && k[0] <= '5' && strings.EqualFold(k[1:], "xx") {
+				classes[int(k[0]-'0')] = ttl
+				continue
+			}
+			code, err := strconv.Atoi(k)
+			if err != nil || code < 100 || code > 599 {
+				return fmt.Errorf("cache client status %q is invalid", k)
+			}
+			codes[code] = ttl
+		}
+		c.statusTTLs = codes
+		c.statusClassTTLs = classes
+		return nil
+	}
+}
+
 // ClientWithSkipCacheResponseHeader sets a response header that prevents
 // successful responses from being stored.
 func ClientWithSkipCacheResponseHeader(header string) ClientOption {
@@ -930,6 +1796,22 @@ func ClientWithSingleflight() ClientOption {
 	}
 }
 
+// ClientWithSingleflightTimeout bounds how long a coalesced request waits
+// for the singleflight leader. Followers that time out call the origin
+// themselves instead of queueing behind a slow leader. Followers always
+// stop waiting when their request context is canceled, and a panic in
+// the leader's handler is answered with 502 Bad Gateway for every
+// follower. Defaults to 0 (wait for the leader).
+func ClientWithSingleflightTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) error {
+		if timeout < 0 {
+			return fmt.Errorf("cache client singleflight timeout %v is invalid", timeout)
+		}
+		c.singleflightTimeout = timeout
+		return nil
+	}
+}
+
 // ClientWithStaleWhileRevalidate enables RFC 5861 stale-while-revalidate
 // semantics: an expired entry whose Expiration is no older than window
 // is served from cache immediately while a single background goroutine
@@ -947,6 +1829,83 @@ func ClientWithStaleWhileRevalidate(window time.Duration) ClientOption {
 	}
 }
 
+// ClientWithSoftPurge makes Drop and PURGE requests mark the matching
+// entry as expired instead of releasing it, when combined with
+// ClientWithStaleWhileRevalidate: the old copy is served while a single
+// background request refreshes it, which avoids the thundering herd a
+// hard purge causes right after a content publish. Without a stale
+// window entries are released as usual and, with
+// ClientWithSingleflight, concurrent misses still coalesce into one
+// origin call. Defaults off.
+func ClientWithSoftPurge() ClientOption {
+	return func(c *Client) error {
+		c.softPurge = true
+		return nil
+	}
+}
+
+// ClientWithRefreshAhead refreshes a valid entry in the background once
+// less than fraction of its TTL remains, e.g. 0.1 refreshes during the
+// last 10% of the entry's lifetime. The refill runs through the same
+// coalesced background path as ClientWithStaleWhileRevalidate, so hot
+// keys are replaced before they ever expire under load. Entries without
+// an expiration are never refreshed ahead. Defaults to 0 (off).
+func ClientWithRefreshAhead(fraction float64) ClientOption {
+	return func(c *Client) error {
+		if fraction < 0 || fraction >= 1 {
+			return fmt.Errorf("cache client refresh-ahead fraction %v must be in [0, 1)", fraction)
+		}
+		c.refreshAheadFraction = fraction
+		return nil
+	}
+}
+
+// ClientWithProbabilisticRefreshAhead enables XFetch-style probabilistic
+// early expiration: every hit refreshes the entry in the background with
+// a probability that rises as its expiration approaches, weighted by how
+// long the origin took to produce it. beta tunes the eagerness; 1 is the
+// recommended value and larger values refresh earlier. Entries that do
+// not record the origin duration, such as those written by Client.Store,
+// are refreshed during the last 10% of their lifetime, or the fraction
+// set by ClientWithRefreshAhead. Defaults to 0 (off).
+func ClientWithProbabilisticRefreshAhead(beta float64) ClientOption {
+	return func(c *Client) error {
+		if beta < 0 {
+			return fmt.Errorf("cache client refresh-ahead beta %v is invalid", beta)
+		}
+		c.refreshAheadBeta = beta
+		return nil
+	}
+}
+
+// ClientWithDebugHeaders adds diagnostic headers to the responses of
+// requests that carry requestHeader set to secret:
+//
+//   - X-Cache: HIT, MISS, STALE (served within the stale window) or
+//     BYPASS (the request skipped the cache).
+//   - X-Cache-Key and X-Cache-Fingerprint: the key the request maps to
+//     (as KeyAsString) and its canonical fingerprint, hex encoded.
+//   - X-Cache-TTL: the remaining TTL of a served entry, in seconds.
+//   - X-Cache-Reason: why the request was a miss (not-found, expired,
+//     corrupt, collision, refresh, request-no-cache, coalesced) or a
+//     bypass (method, path, request-no-store, key-error).
+//   - X-Cache-Store-Skipped: why the response was not stored
+//     (status-code, max-body, skip-header, cache-control, not-written).
+//     A max-body overflow detected after the header was sent is
+//     reported as a trailer.
+//
+// Requests without the secret see no difference. Optional setting.
+func ClientWithDebugHeaders(requestHeader, secret string) ClientOption {
+	return func(c *Client) error {
+		if requestHeader == "" || secret == "" {
+			return errors.New("cache client debug header and secret must be set")
+		}
+		c.debugHeader = requestHeader
+		c.debugSecret = secret
+		return nil
+	}
+}
+
 // ClientWithRespectCacheControl makes the middleware honor a small but
 // useful subset of RFC 7234 Cache-Control directives on both requests
 // and responses:
@@ -996,6 +1955,9 @@ type responseWriter struct {
 	maxBodySize int
 	exceeded    bool
 	wrote       bool
+	// onHeader, when set, runs right before the header is sent to the
+	// client, with the outgoing header and status code.
+	onHeader func(http.Header, int)
 }
 
 func newResponseWriter(w http.ResponseWriter, maxBodySize int) *responseWriter {
@@ -1017,11 +1979,15 @@ func (w *responseWriter) WriteHeader(statusCode int) {
 	w.statusCode = statusCode
 	w.wrote = true
 	writeHeader(w.ResponseWriter.Header(), w.header)
+	if w.onHeader != nil {
+		w.onHeader(w.ResponseWriter.Header(), statusCode)
+	}
 	w.ResponseWriter.WriteHeader(statusCode)
 }
 
 func (w *responseWriter) Write(b []byte) (int, error) {
-	if w.statusCode == 0 {
+	first := w.statusCode == 0
+	if first {
 		w.statusCode = http.StatusOK
 	}
 	w.wrote = true
@@ -1037,6 +2003,9 @@ func (w *responseWriter) Write(b []byte) (int, error) {
 		}
 	}
 	writeHeader(w.ResponseWriter.Header(), w.header)
+	if first && w.onHeader != nil {
+		w.onHeader(w.ResponseWriter.Header(), w.statusCode)
+	}
 	return w.ResponseWriter.Write(b)
 }
 
@@ -1123,35 +2092,89 @@ func (w *captureWriter) statusCodeValue() int {
 // pulling golang.org/x/sync as a direct dependency (which would also
 // bump the module's minimum Go version) for a 40-line primitive.
 type singleflightGroup struct {
-	mu sync.Mutex
-	m  map[string]*sfCall
+	mu      sync.Mutex
+	m       map[string]*sfCall
+	waiters atomic.Int64
 }
 
 type sfCall struct {
-	done chan struct{}
-	val  interface{}
+	done  chan struct{}
+	val   interface{}
+	panic *panicError
+}
+
+// panicError carries a panic raised by a singleflight leader, and the
+// stack it was raised from, to every caller sharing its execution.
+type panicError struct {
+	value interface{}
+	stack []byte
+}
+
+func (p *panicError) Error() string {
+	return fmt.Sprintf("cache: singleflight leader panicked: %v\n\n%s", p.value, p.stack)
 }
 
-func (g *singleflightGroup) Do(key string, fn func() interface{}) (interface{}, bool) {
+// errSingleflightTimeout is returned to followers that gave up waiting
+// for the leader.
+var errSingleflightTimeout = errors.New("cache: singleflight wait timed out")
+
+// Do runs fn once for all concurrent callers of key. shared reports
+// whether the caller was a follower. A panic in fn is recovered and
+// returned as a *panicError to the leader and to every follower, so the
+// key is always released and nobody blocks on a dead leader. Followers
+// stop waiting when ctx is done or, if timeout is positive, once it
+// elapses (errSingleflightTimeout).
+func (g *singleflightGroup) Do(ctx context.Context, key string, timeout time.Duration, fn func() interface{}) (v interface{}, shared bool, err error) {
 	g.mu.Lock()
 	if g.m == nil {
 		g.m = make(map[string]*sfCall)
 	}
 	if call, ok := g.m[key]; ok {
 		g.mu.Unlock()
-		<-call.done
-		return call.val, true
+		g.waiters.Add(1)
+		defer g.waiters.Add(-1)
+		return call.wait(ctx, timeout)
 	}
 	call := &sfCall{done: make(chan struct{})}
 	g.m[key] = call
 	g.mu.Unlock()
 
-	call.val = fn()
-	close(call.done)
+	g.doCall(call, key, fn)
+	if call.panic != nil {
+		return nil, false, call.panic
+	}
+	return call.val, false, nil
+}
 
-	g.mu.Lock()
-	delete(g.m, key)
-	g.mu.Unlock()
+func (g *singleflightGroup) doCall(call *sfCall, key string, fn func() interface{}) {
+	defer func() {
+		if v := recover(); v != nil {
+			call.panic = &panicError{value: v, stack: debug.Stack()}
+		}
+		g.mu.Lock()
+		delete(g.m, key)
+		g.mu.Unlock()
+		close(call.done)
+	}()
+	call.val = fn()
+}
 
-	return call.val, false
+func (call *sfCall) wait(ctx context.Context, timeout time.Duration) (interface{}, bool, error) {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+	select {
+	case <-call.done:
+	case <-expired:
+		return nil, true, errSingleflightTimeout
+	case <-ctx.Done():
+		return nil, true, ctx.Err()
+	}
+	if call.panic != nil {
+		return nil, true, call.panic
+	}
+	return call.val, true, nil
 }
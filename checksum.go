@@ -0,0 +1,176 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cache
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math/bits"
+)
+
+// Checksum is an algorithm protecting stored entries against corruption.
+type Checksum byte
+
+// Checksums supported by ClientWithChecksum. Their values are the
+// envelope flag marking an entry that carries them.
+const (
+	// ChecksumCRC32C appends a 4 byte CRC-32 (Castagnoli) to entries.
+	ChecksumCRC32C Checksum = 1 << 0
+
+	// ChecksumXXHash appends an 8 byte xxHash64 to entries.
+	ChecksumXXHash Checksum = 1 << 1
+)
+
+// ErrChecksumMismatch is returned when decoding an entry whose checksum
+// does not match its content.
+var ErrChecksumMismatch = errors.New("cache: entry checksum mismatch")
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// ClientWithChecksum stores a checksum of the envelope and payload with
+// each entry. On read, an entry whose checksum does not match is
+// released, reported as a corrupt event wrapping ErrChecksumMismatch and
+// the request served from the origin. Entries carrying a checksum are
+// verified whether the option is set or not.
+func ClientWithChecksum(checksum Checksum) ClientOption {
+	return func(c *Client) error {
+		if checksum != ChecksumCRC32C && checksum != ChecksumXXHash {
+			return fmt.Errorf("cache client checksum %d is invalid", checksum)
+		}
+		c.checksum = checksum
+		return nil
+	}
+}
+
+// size returns the length of the checksum.
+func (s Checksum) size() int {
+	if s == ChecksumXXHash {
+		return 8
+	}
+	return 4
+}
+
+// appendChecksum flags the envelope b and appends its checksum.
+func appendChecksum(b []byte, checksum Checksum) []byte {
+	b[4] |= byte(checksum)
+	if checksum == ChecksumXXHash {
+		return binary.BigEndian.AppendUint64(b, xxh64(b))
+	}
+	return binary.BigEndian.AppendUint32(b, crc32.Checksum(b, crc32c))
+}
+
+// verifyChecksum checks the checksum the flags of the envelope b call
+// for and returns b without it.
+func verifyChecksum(b []byte, flags byte) ([]byte, error) {
+	checksum := Checksum(flags)
+	if checksum != ChecksumCRC32C && checksum != ChecksumXXHash {
+		return nil, fmt.Errorf("cache: unsupported entry flags %#x", flags)
+	}
+	n := len(b) - checksum.size()
+	if n < envelopeSize {
+		return nil, ErrChecksumMismatch
+	}
+	var ok bool
+	if checksum == ChecksumXXHash {
+		ok = xxh64(b[:n]) == binary.BigEndian.Uint64(b[n:])
+	} else {
+		ok = crc32.Checksum(b[:n], crc32c) == binary.BigEndian.Uint32(b[n:])
+	}
+	if !ok {
+		return nil, ErrChecksumMismatch
+	}
+	return b[:n:n], nil
+}
+
+// xxHash64 primes. They are variables so the seeded accumulators may
+// wrap around.
+var (
+	xxPrime1 uint64 = 11400714785074694791
+	xxPrime2 uint64 = 14029467366897019727
+	xxPrime3 uint64 = 1609587929392839161
+	xxPrime4 uint64 = 9650029242287828579
+	xxPrime5 uint64 = 2870177450012600261
+)
+
+// xxh64 returns the xxHash64 of b with a zero seed. It is implemented
+// here so the core package does not depend on a hashing module.
+func xxh64(b []byte) uint64 {
+	n := len(b)
+	var h uint64
+	if n >= 32 {
+		v1 := xxPrime1 + xxPrime2
+		v2 := xxPrime2
+		v3 := uint64(0)
+		v4 := -xxPrime1
+		for ; len(b) >= 32; b = b[32:] {
+			v1 = xxRound(v1, binary.LittleEndian.Uint64(b[0:]))
+			v2 = xxRound(v2, binary.LittleEndian.Uint64(b[8:]))
+			v3 = xxRound(v3, binary.LittleEndian.Uint64(b[16:]))
+			v4 = xxRound(v4, binary.LittleEndian.Uint64(b[24:]))
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) +
+			bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = xxMerge(h, v1)
+		h = xxMerge(h, v2)
+		h = xxMerge(h, v3)
+		h = xxMerge(h, v4)
+	} else {
+		h = xxPrime5
+	}
+	h += uint64(n)
+
+	for ; len(b) >= 8; b = b[8:] {
+		h ^= xxRound(0, binary.LittleEndian.Uint64(b))
+		h = bits.RotateLeft64(h, 27)*xxPrime1 + xxPrime4
+	}
+	if len(b) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(b)) * xxPrime1
+		h = bits.RotateLeft64(h, 23)*xxPrime2 + xxPrime3
+		b = b[4:]
+	}
+	for _, c := range b {
+		h ^= uint64(c) * xxPrime5
+		h = bits.RotateLeft64(h, 11) * xxPrime1
+	}
+
+	h ^= h >> 33
+	h *= xxPrime2
+	h ^= h >> 29
+	h *= xxPrime3
+	h ^= h >> 32
+	return h
+}
+
+func xxRound(acc, input uint64) uint64 {
+	acc += input * xxPrime2
+	return bits.RotateLeft64(acc, 31) * xxPrime1
+}
+
+func xxMerge(h, v uint64) uint64 {
+	h ^= xxRound(0, v)
+	return h*xxPrime1 + xxPrime4
+}
@@ -0,0 +1,105 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestChecksumsRoundTrip(t *testing.T) {
+	want := codecTestResponse()
+	for _, checksum := range []Checksum{ChecksumCRC32C, ChecksumXXHash} {
+		for _, codec := range []Codec{GobCodec{}, BinaryCodec{}} {
+			client := &Client{codec: codec, checksum: checksum}
+			b, err := client.encode(want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if Checksum(b[4]) != checksum {
+				t.Errorf("flags = %#x, want %#x", b[4], checksum)
+			}
+			got, err := client.decode(b)
+			if err != nil {
+				t.Fatalf("checksum %d, codec %d: decode error = %v", checksum, codec.ID(), err)
+			}
+			got.materialize()
+			assertSameResponse(t, got, want)
+
+			b[len(b)/2] ^= 0x01
+			if _, err := client.decode(b); !errors.Is(err, ErrChecksumMismatch) {
+				t.Errorf("checksum %d, codec %d: decoding a flipped byte error = %v, want ErrChecksumMismatch", checksum, codec.ID(), err)
+			}
+		}
+	}
+}
+
+// A corrupted body must not be served: the entry is released, reported
+// and refetched from the origin.
+func TestClientWithChecksumRejectsCorruptEntries(t *testing.T) {
+	const url = "http://x/checksum"
+	adapter := &adapterMock{store: map[uint64][]byte{}}
+	recorder := &eventRecorder{}
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(time.Minute),
+		ClientWithCodec(BinaryCodec{}),
+		ClientWithChecksum(ChecksumCRC32C),
+		ClientWithObserver(recorder.observe),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte("intact"))
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, url, nil))
+
+	b, _ := adapter.Get(generateKey(url))
+	corrupted := append([]byte(nil), b...)
+	corrupted[len(corrupted)-5] = 'X' // last body byte, before the CRC
+	adapter.Set(generateKey(url), corrupted, time.Now().Add(time.Minute))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+	if w.Body.String() != "intact" || calls != 2 {
+		t.Fatalf("body = %q after %d origin calls, want intact from the origin", w.Body.String(), calls)
+	}
+	if e := recorder.last(t, CacheEventCorrupt); !errors.Is(e.Err, ErrChecksumMismatch) {
+		t.Errorf("corrupt event error = %v, want ErrChecksumMismatch", e.Err)
+	}
+
+	// Entries with a checksum are verified by clients without the option.
+	plain, err := NewClient(ClientWithAdapter(adapter), ClientWithTTL(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry, err := plain.Lookup(context.Background(), httptest.NewRequest(http.MethodGet, url, nil)); err != nil || string(entry.Response.Value) != "intact" {
+		t.Errorf("Lookup() = %q, %v; want the refetched entry", entry.Response.Value, err)
+	}
+
+	if _, err := NewClient(ClientWithAdapter(adapter), ClientWithTTL(time.Minute), ClientWithChecksum(3)); err == nil {
+		t.Error("NewClient() with an invalid checksum error = nil, want error")
+	}
+}
+
+func TestXXH64(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want uint64
+	}{
+		{"", 0xef46db3751d8e999},
+		{"a", 0xd24ec4f1a98c6e5b},
+		{"abc", 0x44bc2cf5ad770999},
+		{"0123456789abcdef0123456789abcdef", 0x642a94958e71e6c5},
+		{"The quick brown fox jumps over the lazy dog, and keeps running past 32 bytes.", 0x5416b115d37baf8c},
+	} {
+		if got := xxh64([]byte(tt.in)); got != tt.want {
+			t.Errorf("xxh64(%q) = %#x, want %#x", tt.in, got, tt.want)
+		}
+	}
+}
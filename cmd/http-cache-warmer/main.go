@@ -0,0 +1,171 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Command http-cache-warmer replays a sitemap or URL list against a
+// server running the http-cache middleware and reports, per URL, the
+// status code and, with -status-header, whether the response was served
+// from cache.
+//
+// Usage:
+//
+//	http-cache-warmer -input sitemap.xml [flags]
+//
+// The input is a file, "-" for standard input, or an http(s) URL. It can
+// be a sitemap (indexes are followed), a newline-separated URL list or
+// JSON Lines with {"url": ..., "method": ..., "headers": {...}} objects.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/victorspringer/http-cache/warmer"
+)
+
+type headerFlag []string
+
+func (h *headerFlag) String() string     { return strings.Join(*h, ", ") }
+func (h *headerFlag) Set(v string) error { *h = append(*h, v); return nil }
+
+func main() {
+	var (
+		headers  headerFlag
+		variants headerFlag
+	)
+	input := flag.String("input", "", `sitemap or URL list: a file, "-" for stdin, or an http(s) URL`)
+	base := flag.String("base", "", "replace the scheme and host of every URL, e.g. http://10.0.0.7:8080")
+	concurrency := flag.Int("c", 8, "number of requests in flight")
+	timeout := flag.Duration("timeout", 30*time.Second, "per-request timeout")
+	statusHeader := flag.String("status-header", "", "response header carrying the cache status, e.g. X-Cache")
+	flag.Var(&headers, "H", `header sent with every request, "Name: value" (repeatable)`)
+	flag.Var(&variants, "variant", `replay every URL once per variant header set, "Name: value[; Name: value]" (repeatable)`)
+	flag.Parse()
+
+	if *input == "" {
+		fmt.Fprintln(os.Stderr, "http-cache-warmer: -input is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	header, err := parseHeaders(headers)
+	if err != nil {
+		fatal(err)
+	}
+	var variantHeaders []http.Header
+	for _, v := range variants {
+		h, err := parseHeaders(strings.Split(v, ";"))
+		if err != nil {
+			fatal(err)
+		}
+		variantHeaders = append(variantHeaders, h)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	client := &http.Client{Timeout: *timeout}
+	targets, err := warmer.Load(ctx, client, *input)
+	if err != nil {
+		fatal(err)
+	}
+
+	start := time.Now()
+	results := warmer.Run(ctx, targets, warmer.Options{
+		Client:       client,
+		Concurrency:  *concurrency,
+		Header:       header,
+		Variants:     variantHeaders,
+		BaseURL:      *base,
+		StatusHeader: *statusHeader,
+	})
+
+	counts := map[string]int{}
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+			fmt.Printf("ERROR %s %v\n", r.URL, r.Err)
+			continue
+		}
+		status := r.Cache
+		if status == "" {
+			status = "-"
+		}
+		counts[status]++
+		fmt.Printf("%s %d %s %s%s\n", status, r.StatusCode, r.Duration.Round(time.Millisecond), r.URL, formatVariant(r.Header))
+	}
+
+	fmt.Fprintf(os.Stderr, "%d requests in %s:", len(results), time.Since(start).Round(time.Millisecond))
+	statuses := make([]string, 0, len(counts))
+	for status := range counts {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	for _, status := range statuses {
+		fmt.Fprintf(os.Stderr, " %s=%d", status, counts[status])
+	}
+	fmt.Fprintf(os.Stderr, " errors=%d\n", failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func parseHeaders(values []string) (http.Header, error) {
+	header := http.Header{}
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(v, ":")
+		if !ok {
+			return nil, fmt.Errorf("http-cache-warmer: invalid header %q, want \"Name: value\"", v)
+		}
+		header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	return header, nil
+}
+
+func formatVariant(h http.Header) string {
+	if len(h) == 0 {
+		return ""
+	}
+	var parts []string
+	for k, vs := range h {
+		parts = append(parts, k+": "+strings.Join(vs, ","))
+	}
+	return " [" + strings.Join(parts, "; ") + "]"
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
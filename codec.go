@@ -0,0 +1,244 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Codec serializes the Response stored in the adapter. The bytes it
+// produces are wrapped in an envelope whose header identifies the codec,
+// so an entry written with another codec is detected instead of being
+// misread.
+type Codec interface {
+	// ID identifies the codec in the envelope header. IDs up to 15 are
+	// reserved for the codecs of this module.
+	ID() byte
+
+	// Marshal encodes r.
+	Marshal(r Response) ([]byte, error)
+
+	// Unmarshal decodes b, as returned by Marshal, into r.
+	Unmarshal(b []byte, r *Response) error
+}
+
+// IDs of the codecs of this module.
+const (
+	CodecIDGob      byte = 1
+	CodecIDJSON     byte = 2
+	CodecIDMsgpack  byte = 3
+	CodecIDProtobuf byte = 4
+)
+
+// ErrCodecMismatch is returned when decoding an entry written with a
+// codec the client does not use.
+var ErrCodecMismatch = errors.New("cache: entry written with another codec")
+
+// Stored entries start with a 5 byte envelope header: the magic bytes
+// "HC", the envelope version, the codec ID and a flags byte, whose bits
+// mark a trailing checksum (see Checksum). Entries written by older
+// versions of this package have no header and are gob streams, whose
+// first byte is the length of the Response type definition and never
+// 'H'.
+const (
+	envelopeMagic   = "HC"
+	envelopeVersion = 1
+	envelopeSize    = 5
+)
+
+// GobCodec encodes responses with encoding/gob. It is the default codec.
+type GobCodec struct{}
+
+// ID implements the Codec interface.
+func (GobCodec) ID() byte { return CodecIDGob }
+
+// Marshal implements the Codec interface.
+func (GobCodec) Marshal(r Response) ([]byte, error) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(&r); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// Unmarshal implements the Codec interface.
+func (GobCodec) Unmarshal(b []byte, r *Response) error {
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(r)
+}
+
+// JSONCodec encodes responses with encoding/json, so services written in
+// other languages sharing the backend can read them. Value and
+// CanonicalKey are base64 strings, times RFC 3339 strings and
+// OriginDuration a number of nanoseconds.
+type JSONCodec struct{}
+
+// ID implements the Codec interface.
+func (JSONCodec) ID() byte { return CodecIDJSON }
+
+// Marshal implements the Codec interface.
+func (JSONCodec) Marshal(r Response) ([]byte, error) {
+	return json.Marshal(&r)
+}
+
+// Unmarshal implements the Codec interface.
+func (JSONCodec) Unmarshal(b []byte, r *Response) error {
+	return json.Unmarshal(b, r)
+}
+
+// ClientWithCodec sets the codec used to store responses. The default is
+// GobCodec. Entries written with gob, JSON or BinaryCodec are still read
+// after a switch; entries written with another codec are treated as corrupt.
+func ClientWithCodec(codec Codec) ClientOption {
+	return func(c *Client) error {
+		if codec == nil {
+			return errors.New("cache client codec is nil")
+		}
+		c.codec = codec
+		return nil
+	}
+}
+
+// ClientWithLegacyEncoding stores plain gob streams without the envelope
+// header, as versions of this package before codecs were introduced do.
+// Those versions cannot read enveloped entries, so during a rolling
+// deploy against a shared backend keep this option until every instance
+// is upgraded, then drop it. NewClient fails when it is combined with
+// a codec other than GobCodec or with a checksum. Optional setting.
+func ClientWithLegacyEncoding() ClientOption {
+	return func(c *Client) error {
+		c.legacyEncoding = true
+		return nil
+	}
+}
+
+// BytesToResponse converts bytes array into Response data structure
+// using the client's codec. Decoding errors are silently swallowed, as
+// in the package-level BytesToResponse.
+func (c *Client) BytesToResponse(b []byte) Response {
+	r, _ := c.decode(b)
+	r.materialize()
+	return r
+}
+
+// encode serializes r with the client's codec and checksum.
+func (c *Client) encode(r Response) ([]byte, error) {
+	codec := c.codec
+	if codec == nil {
+		codec = GobCodec{}
+	}
+	if c.legacyEncoding {
+		r.materialize()
+		return codec.Marshal(r)
+	}
+	b, err := encodeResponse(codec, r)
+	if err != nil || c.checksum == 0 {
+		return b, err
+	}
+	return appendChecksum(b, c.checksum), nil
+}
+
+// decode deserializes an entry written with the client's codec, gob or
+// JSON.
+func (c *Client) decode(b []byte) (Response, error) {
+	return decodeResponse(c.codec, b)
+}
+
+// codecAppender is implemented by codecs that can encode straight after
+// the envelope header, saving a copy.
+type codecAppender interface {
+	appendTo(dst []byte, r Response) ([]byte, error)
+}
+
+// encodeResponse serializes r with codec, in an envelope.
+func encodeResponse(codec Codec, r Response) ([]byte, error) {
+	if a, ok := codec.(codecAppender); ok {
+		header := [envelopeSize]byte{envelopeMagic[0], envelopeMagic[1], envelopeVersion, codec.ID()}
+		return a.appendTo(header[:], r)
+	}
+	r.materialize()
+	payload, err := codec.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, envelopeSize, envelopeSize+len(payload))
+	copy(b, envelopeMagic)
+	b[2] = envelopeVersion
+	b[3] = codec.ID()
+	return append(b, payload...), nil
+}
+
+// decodeResponse returns a Response and any error from decoding b, so
+// callers can distinguish empty/corrupt entries from zero-valued ones.
+// codec, which may be nil, is used for entries carrying its ID. Entries
+// written with BinaryCodec are decoded lazily: materialize the response
+// before handing it out.
+func decodeResponse(codec Codec, b []byte) (Response, error) {
+	if len(b) == 0 {
+		return Response{}, errors.New("cache: empty response payload")
+	}
+	if len(b) < envelopeSize || string(b[:2]) != envelopeMagic {
+		return unmarshalResponse(GobCodec{}, b)
+	}
+	if b[2] != envelopeVersion {
+		return Response{}, fmt.Errorf("cache: unsupported entry version %d", b[2])
+	}
+	if flags := b[4]; flags != 0 {
+		var err error
+		if b, err = verifyChecksum(b, flags); err != nil {
+			return Response{}, err
+		}
+	}
+
+	id := b[3]
+	switch {
+	case id == CodecIDBinary:
+		// Decoded lazily, without going through Unmarshal.
+		var r Response
+		if err := decodeBinary(b[envelopeSize:], &r); err != nil {
+			return Response{}, err
+		}
+		return r, nil
+	case codec != nil && codec.ID() == id:
+	case id == CodecIDGob:
+		codec = GobCodec{}
+	case id == CodecIDJSON:
+		codec = JSONCodec{}
+	default:
+		return Response{}, fmt.Errorf("%w (codec ID %d)", ErrCodecMismatch, id)
+	}
+	return unmarshalResponse(codec, b[envelopeSize:])
+}
+
+func unmarshalResponse(codec Codec, b []byte) (Response, error) {
+	var r Response
+	if err := codec.Unmarshal(b, &r); err != nil {
+		return Response{}, err
+	}
+	return r, nil
+}
@@ -0,0 +1,18 @@
+module github.com/victorspringer/http-cache/codec/msgpack
+
+go 1.23.0
+
+require (
+	github.com/victorspringer/http-cache v0.0.0-00010101000000-000000000000
+	github.com/vmihailenco/msgpack v4.0.4+incompatible
+)
+
+require (
+	github.com/golang/protobuf v1.5.2 // indirect
+	github.com/kr/text v0.2.0 // indirect
+	golang.org/x/net v0.43.0 // indirect
+	google.golang.org/appengine v1.6.7 // indirect
+	google.golang.org/protobuf v1.36.8 // indirect
+)
+
+replace github.com/victorspringer/http-cache => ../..
@@ -0,0 +1,75 @@
+package msgpack
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	cache "github.com/victorspringer/http-cache"
+	"github.com/victorspringer/http-cache/adapter/memory"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	now := time.Now().Round(0)
+	want := cache.Response{
+		Value:          []byte("body"),
+		Header:         http.Header{"Content-Type": {"text/plain"}, "Set-Cookie": {"a=1", "b=2"}},
+		Expiration:     now.Add(time.Minute),
+		LastAccess:     now,
+		Frequency:      3,
+		CanonicalKey:   []byte("fingerprint"),
+		StoredAt:       now,
+		OriginDuration: 42 * time.Millisecond,
+		URL:            "http://x/msgpack",
+		Namespace:      "shop",
+	}
+	b, err := Codec{}.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got cache.Response
+	if err := (Codec{}).Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Value, want.Value) || !reflect.DeepEqual(got.Header, want.Header) ||
+		!got.Expiration.Equal(want.Expiration) || !got.LastAccess.Equal(want.LastAccess) ||
+		got.Frequency != want.Frequency || !bytes.Equal(got.CanonicalKey, want.CanonicalKey) ||
+		!got.StoredAt.Equal(want.StoredAt) || got.OriginDuration != want.OriginDuration || got.URL != want.URL ||
+		got.Namespace != want.Namespace {
+		t.Errorf("decoded response = %+v, want %+v", got, want)
+	}
+}
+
+func TestClientWithMsgpackCodec(t *testing.T) {
+	adapter, err := memory.NewAdapter(memory.AdapterWithAlgorithm(memory.LRU), memory.AdapterWithCapacity(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := cache.NewClient(
+		cache.ClientWithAdapter(adapter),
+		cache.ClientWithTTL(time.Minute),
+		cache.ClientWithCodec(Codec{}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := 0
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte("msgpack"))
+	}))
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://x/msgpack", nil))
+		if w.Body.String() != "msgpack" {
+			t.Fatalf("body = %q, want msgpack", w.Body.String())
+		}
+	}
+	if calls != 1 {
+		t.Errorf("origin called %d times, want 1", calls)
+	}
+}
@@ -0,0 +1,10 @@
+module github.com/victorspringer/http-cache/codec/protobuf
+
+go 1.23.0
+
+require (
+	github.com/victorspringer/http-cache v0.0.0-00010101000000-000000000000
+	google.golang.org/protobuf v1.36.8
+)
+
+replace github.com/victorspringer/http-cache => ../..
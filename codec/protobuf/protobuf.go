@@ -0,0 +1,224 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package protobuf provides a Protocol Buffers codec for the cache
+// Response. Entries follow this schema:
+//
+//	message Response {
+//	  bytes value = 1;
+//	  repeated Header header = 2;
+//	  int64 expiration = 3;      // Unix nanoseconds, absent if zero
+//	  int64 last_access = 4;     // Unix nanoseconds, absent if zero
+//	  int64 frequency = 5;
+//	  bytes canonical_key = 6;
+//	  int64 stored_at = 7;       // Unix nanoseconds, absent if zero
+//	  int64 origin_duration = 8; // nanoseconds
+//	  string url = 9;
+//	  string namespace = 10;
+//	}
+//
+//	message Header {
+//	  string name = 1;
+//	  repeated string values = 2;
+//	}
+package protobuf
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	cache "github.com/victorspringer/http-cache"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Codec encodes responses as Protocol Buffers messages.
+type Codec struct{}
+
+// ID implements the cache.Codec interface.
+func (Codec) ID() byte { return cache.CodecIDProtobuf }
+
+// Marshal implements the cache.Codec interface.
+func (Codec) Marshal(r cache.Response) ([]byte, error) {
+	var b []byte
+	if len(r.Value) > 0 {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, r.Value)
+	}
+
+	// Sort header names so equal responses encode to equal bytes.
+	names := make([]string, 0, len(r.Header))
+	for name := range r.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var h []byte
+		h = protowire.AppendTag(h, 1, protowire.BytesType)
+		h = protowire.AppendString(h, name)
+		for _, v := range r.Header[name] {
+			h = protowire.AppendTag(h, 2, protowire.BytesType)
+			h = protowire.AppendString(h, v)
+		}
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, h)
+	}
+
+	b = appendTime(b, 3, r.Expiration)
+	b = appendTime(b, 4, r.LastAccess)
+	b = appendInt(b, 5, int64(r.Frequency))
+	if len(r.CanonicalKey) > 0 {
+		b = protowire.AppendTag(b, 6, protowire.BytesType)
+		b = protowire.AppendBytes(b, r.CanonicalKey)
+	}
+	b = appendTime(b, 7, r.StoredAt)
+	b = appendInt(b, 8, int64(r.OriginDuration))
+	if r.URL != "" {
+		b = protowire.AppendTag(b, 9, protowire.BytesType)
+		b = protowire.AppendString(b, r.URL)
+	}
+	if r.Namespace != "" {
+		b = protowire.AppendTag(b, 10, protowire.BytesType)
+		b = protowire.AppendString(b, r.Namespace)
+	}
+	return b, nil
+}
+
+func appendInt(b []byte, num protowire.Number, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+func appendTime(b []byte, num protowire.Number, t time.Time) []byte {
+	if t.IsZero() {
+		return b
+	}
+	return appendInt(b, num, t.UnixNano())
+}
+
+// Unmarshal implements the cache.Codec interface. Unknown fields are
+// skipped.
+func (Codec) Unmarshal(b []byte, r *cache.Response) error {
+	*r = cache.Response{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch {
+		case typ == protowire.BytesType && (num == 1 || num == 2 || num == 6 || num == 9 || num == 10):
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			switch num {
+			case 1:
+				r.Value = append([]byte(nil), v...)
+			case 2:
+				if r.Header == nil {
+					r.Header = http.Header{}
+				}
+				if err := unmarshalHeader(v, r.Header); err != nil {
+					return err
+				}
+			case 6:
+				r.CanonicalKey = append([]byte(nil), v...)
+			case 9:
+				r.URL = string(v)
+			case 10:
+				r.Namespace = string(v)
+			}
+		case typ == protowire.VarintType && num >= 3 && num <= 8 && num != 6:
+			u, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			v := int64(u)
+			switch num {
+			case 3:
+				r.Expiration = time.Unix(0, v)
+			case 4:
+				r.LastAccess = time.Unix(0, v)
+			case 5:
+				r.Frequency = int(v)
+			case 7:
+				r.StoredAt = time.Unix(0, v)
+			case 8:
+				r.OriginDuration = time.Duration(v)
+			}
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+	return nil
+}
+
+func unmarshalHeader(b []byte, header http.Header) error {
+	var (
+		name   string
+		values []string
+	)
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ != protowire.BytesType || (num != 1 && num != 2) {
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if num == 1 {
+			name = string(v)
+		} else {
+			values = append(values, string(v))
+		}
+	}
+	if name == "" {
+		return errors.New("protobuf codec: header without name")
+	}
+	header[name] = append(header[name], values...)
+	return nil
+}
@@ -0,0 +1,193 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func codecTestResponse() Response {
+	now := time.Now().Round(0)
+	return Response{
+		Value:          []byte("body"),
+		Header:         http.Header{"Content-Type": {"text/plain"}, "Set-Cookie": {"a=1", "b=2"}},
+		Expiration:     now.Add(time.Minute),
+		LastAccess:     now,
+		Frequency:      3,
+		CanonicalKey:   []byte("fingerprint"),
+		StoredAt:       now,
+		OriginDuration: 42 * time.Millisecond,
+		URL:            "http://x/codec?a=1",
+		Namespace:      "shop",
+	}
+}
+
+func assertSameResponse(t *testing.T, got, want Response) {
+	t.Helper()
+	if !bytes.Equal(got.Value, want.Value) || !reflect.DeepEqual(got.Header, want.Header) ||
+		!got.Expiration.Equal(want.Expiration) || !got.LastAccess.Equal(want.LastAccess) ||
+		got.Frequency != want.Frequency || !bytes.Equal(got.CanonicalKey, want.CanonicalKey) ||
+		!got.StoredAt.Equal(want.StoredAt) || got.OriginDuration != want.OriginDuration || got.URL != want.URL ||
+		got.Namespace != want.Namespace {
+		t.Errorf("decoded response = %+v, want %+v", got, want)
+	}
+}
+
+func TestCodecsRoundTrip(t *testing.T) {
+	want := codecTestResponse()
+	for _, codec := range []Codec{GobCodec{}, JSONCodec{}} {
+		client := &Client{codec: codec}
+		b, err := client.encode(want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b[:2]) != envelopeMagic || b[3] != codec.ID() {
+			t.Errorf("codec %d: envelope header = %v", codec.ID(), b[:envelopeSize])
+		}
+		got, err := client.decode(b)
+		if err != nil {
+			t.Fatalf("codec %d: decode error = %v", codec.ID(), err)
+		}
+		assertSameResponse(t, got, want)
+		assertSameResponse(t, client.BytesToResponse(b), want)
+	}
+}
+
+// Entries written before the envelope was introduced are plain gob
+// streams and must still be served.
+func TestDecodeLegacyGobEntries(t *testing.T) {
+	want := codecTestResponse()
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(&want); err != nil {
+		t.Fatal(err)
+	}
+	if string(b.Bytes()[:2]) == envelopeMagic {
+		t.Fatal("a legacy gob entry starts with the envelope magic")
+	}
+
+	client := &Client{codec: JSONCodec{}}
+	got, err := client.decode(b.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSameResponse(t, got, want)
+	assertSameResponse(t, BytesToResponse(b.Bytes()), want)
+}
+
+type fakeCodec struct {
+	JSONCodec
+}
+
+func (fakeCodec) ID() byte { return 99 }
+
+func TestDecodeDetectsForeignEntries(t *testing.T) {
+	foreign, err := encodeResponse(fakeCodec{}, codecTestResponse())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decodeResponse(GobCodec{}, foreign); !errors.Is(err, ErrCodecMismatch) {
+		t.Errorf("decode error = %v, want ErrCodecMismatch", err)
+	}
+
+	newer := append([]byte(nil), foreign...)
+	newer[2] = envelopeVersion + 1
+	if _, err := decodeResponse(fakeCodec{}, newer); err == nil {
+		t.Error("decoding an entry with an unknown envelope version error = nil, want error")
+	}
+}
+
+// A client switching codecs must treat entries it cannot read as
+// corrupt and serve the request from the origin.
+func TestClientWithCodecTreatsForeignEntriesAsCorrupt(t *testing.T) {
+	const url = "http://x/foreign"
+	foreign, err := encodeResponse(fakeCodec{}, Response{Value: []byte("foreign"), Expiration: time.Now().Add(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	adapter := &adapterMock{store: map[uint64][]byte{generateKey(url): foreign}}
+	recorder := &eventRecorder{}
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(time.Minute),
+		ClientWithCodec(JSONCodec{}),
+		ClientWithObserver(recorder.observe),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("origin"))
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+	if w.Body.String() != "origin" {
+		t.Fatalf("body = %q, want origin", w.Body.String())
+	}
+	if e := recorder.last(t, CacheEventCorrupt); !errors.Is(e.Err, ErrCodecMismatch) {
+		t.Errorf("corrupt event error = %v, want ErrCodecMismatch", e.Err)
+	}
+
+	b, _ := adapter.Get(generateKey(url))
+	if b[3] != CodecIDJSON {
+		t.Errorf("stored codec ID = %d, want JSON", b[3])
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, url, nil))
+	if hit := recorder.last(t, CacheEventHit); hit.Size != len("origin") {
+		t.Errorf("hit = %+v, want the entry stored as JSON", hit)
+	}
+
+	if _, err := NewClient(ClientWithAdapter(adapter), ClientWithTTL(time.Minute), ClientWithCodec(nil)); err == nil {
+		t.Error("NewClient() with a nil codec error = nil, want error")
+	}
+}
+
+// Instances not yet upgraded decode entries as plain gob streams: with
+// legacy encoding, the stored entry must be one.
+func TestClientWithLegacyEncoding(t *testing.T) {
+	const url = "http://x/legacy"
+	adapter := &adapterMock{store: map[uint64][]byte{}}
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(time.Minute),
+		ClientWithLegacyEncoding(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("origin"))
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, url, nil))
+
+	b, _ := adapter.Get(generateKey(url))
+	var legacy Response
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&legacy); err != nil {
+		t.Fatalf("decoding the entry as a plain gob stream error = %v", err)
+	}
+	if string(legacy.Value) != "origin" {
+		t.Errorf("legacy value = %q, want origin", legacy.Value)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+	if w.Body.String() != "origin" {
+		t.Errorf("hit body = %q, want origin", w.Body.String())
+	}
+
+	for name, opt := range map[string]ClientOption{
+		"binary codec": ClientWithCodec(BinaryCodec{}),
+		"checksum":     ClientWithChecksum(ChecksumCRC32C),
+	} {
+		if _, err := NewClient(ClientWithAdapter(adapter), ClientWithTTL(time.Minute), ClientWithLegacyEncoding(), opt); err == nil {
+			t.Errorf("NewClient() with legacy encoding and a %s error = nil, want error", name)
+		}
+	}
+	if _, err := NewClient(ClientWithAdapter(adapter), ClientWithTTL(time.Minute), ClientWithLegacyEncoding(), ClientWithCodec(GobCodec{})); err != nil {
+		t.Errorf("NewClient() with legacy encoding and GobCodec error = %v", err)
+	}
+}
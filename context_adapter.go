@@ -0,0 +1,223 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// ErrAdapterUnavailable is returned, possibly wrapped, by a ContextAdapter
+// that refuses to reach its backend, e.g. while a circuit breaker is
+// open. The middleware then bypasses the cache for the request instead
+// of reporting an error.
+var ErrAdapterUnavailable = errors.New("cache: adapter unavailable")
+
+// ContextAdapter is the context-aware version of Adapter. Its methods
+// take the request context and report backend failures, so the
+// middleware can tell a miss from an unreachable store: failures are
+// reported to the observer as error events and the request is served by
+// the origin. Adapters implementing ContextAdapter next to Adapter are
+// used through it.
+type ContextAdapter interface {
+	// GetContext retrieves the cached response by a given key. A missing
+	// key is not an error: it returns false and a nil error.
+	GetContext(ctx context.Context, key uint64) ([]byte, bool, error)
+
+	// SetContext caches a response for a given key until an expiration
+	// date.
+	SetContext(ctx context.Context, key uint64, response []byte, expiration time.Time) error
+
+	// ReleaseContext frees cache for a given key.
+	ReleaseContext(ctx context.Context, key uint64) error
+}
+
+// ToContextAdapter returns a as a ContextAdapter: a itself when it
+// implements the interface, otherwise a shim whose methods fail only
+// when ctx is done before calling a.
+func ToContextAdapter(a Adapter) ContextAdapter {
+	if ca, ok := a.(ContextAdapter); ok {
+		return ca
+	}
+	return contextShim{a}
+}
+
+type contextShim struct {
+	Adapter
+}
+
+func (s contextShim) GetContext(ctx context.Context, key uint64) ([]byte, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+	b, ok := s.Get(key)
+	return b, ok, nil
+}
+
+func (s contextShim) SetContext(ctx context.Context, key uint64, response []byte, expiration time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.Set(key, response, expiration)
+	return nil
+}
+
+func (s contextShim) ReleaseContext(ctx context.Context, key uint64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.Release(key)
+	return nil
+}
+
+// ToAdapter returns a as an Adapter: a itself when it implements the
+// interface, otherwise a shim calling its context methods with a
+// background context. The client uses the context methods of the shim
+// and sees the optional interfaces of a through it.
+func ToAdapter(a ContextAdapter) Adapter {
+	if la, ok := a.(Adapter); ok {
+		return la
+	}
+	return adapterShim{a}
+}
+
+type adapterShim struct {
+	ContextAdapter
+}
+
+func (s adapterShim) Get(key uint64) ([]byte, bool) {
+	b, ok, _ := s.GetContext(context.Background(), key)
+	return b, ok
+}
+
+func (s adapterShim) Set(key uint64, response []byte, expiration time.Time) {
+	s.SetContext(context.Background(), key, response, expiration)
+}
+
+func (s adapterShim) Release(key uint64) {
+	s.ReleaseContext(context.Background(), key)
+}
+
+func (s adapterShim) GetWide(ctx context.Context, key string) ([]byte, bool, error) {
+	wa, ok := s.ContextAdapter.(WideKeyAdapter)
+	if !ok {
+		return nil, false, errNoWideKeys
+	}
+	return wa.GetWide(ctx, key)
+}
+
+func (s adapterShim) SetWide(ctx context.Context, key string, response []byte, expiration time.Time) error {
+	wa, ok := s.ContextAdapter.(WideKeyAdapter)
+	if !ok {
+		return errNoWideKeys
+	}
+	return wa.SetWide(ctx, key, response, expiration)
+}
+
+func (s adapterShim) ReleaseWide(ctx context.Context, key string) error {
+	wa, ok := s.ContextAdapter.(WideKeyAdapter)
+	if !ok {
+		return errNoWideKeys
+	}
+	return wa.ReleaseWide(ctx, key)
+}
+
+// errNoWideKeys is returned by the wide key methods of adapterShim when
+// the ContextAdapter does not implement WideKeyAdapter.
+var errNoWideKeys = errors.New("cache: adapter does not take wide keys")
+
+// ClientWithContextAdapter sets an adapter implementing only
+// ContextAdapter, as ClientWithAdapter(ToAdapter(a)) does.
+func ClientWithContextAdapter(a ContextAdapter) ClientOption {
+	return func(c *Client) error {
+		if a == nil {
+			return errors.New("cache client context adapter is nil")
+		}
+		return ClientWithAdapter(ToAdapter(a))(c)
+	}
+}
+
+// get, set and release go through the WideKeyAdapter methods when wide
+// is set (see wideKey), else through the ContextAdapter methods when the
+// adapter has them. Legacy adapters are called directly: the middleware
+// must keep serving a request whose context is done, and the shim would
+// fail it.
+func (c *Client) get(ctx context.Context, key uint64, wide string) ([]byte, bool, error) {
+	if wa, ok := c.adapter.(WideKeyAdapter); ok && wide != "" {
+		return wa.GetWide(ctx, wide)
+	}
+	if ca, ok := c.adapter.(ContextAdapter); ok {
+		return ca.GetContext(ctx, key)
+	}
+	b, ok := c.adapter.Get(key)
+	return b, ok, nil
+}
+
+func (c *Client) set(ctx context.Context, key uint64, wide string, response []byte, expiration time.Time) error {
+	if wa, ok := c.adapter.(WideKeyAdapter); ok && wide != "" {
+		return wa.SetWide(ctx, wide, response, expiration)
+	}
+	if ca, ok := c.adapter.(ContextAdapter); ok {
+		return ca.SetContext(ctx, key, response, expiration)
+	}
+	c.adapter.Set(key, response, expiration)
+	return nil
+}
+
+func (c *Client) release(ctx context.Context, key uint64, wide string) error {
+	if wa, ok := c.adapter.(WideKeyAdapter); ok && wide != "" {
+		return wa.ReleaseWide(ctx, wide)
+	}
+	if ca, ok := c.adapter.(ContextAdapter); ok {
+		return ca.ReleaseContext(ctx, key)
+	}
+	c.adapter.Release(key)
+	return nil
+}
+
+// canceledBy reports whether err only says that the context of r was
+// canceled, e.g. because the client went away: that is no backend
+// failure.
+func canceledBy(r *http.Request, err error) bool {
+	return errors.Is(err, context.Canceled) && r.Context().Err() != nil
+}
+
+// releaseEntry releases key for r, reporting a failure.
+func (c *Client) releaseEntry(r *http.Request, key uint64, wide string) {
+	if err := c.release(r.Context(), key, wide); err != nil {
+		c.adapterError(r, key, wide, err)
+	}
+}
+
+// adapterError reports a failed adapter call for r, unless r was
+// canceled.
+func (c *Client) adapterError(r *http.Request, key uint64, wide string, err error) {
+	if canceledBy(r, err) {
+		return
+	}
+	c.emit(CacheEvent{Type: CacheEventError, Request: r, Key: key, WideKey: wide, Reason: ReasonAdapterError, Err: err})
+}
@@ -0,0 +1,238 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var errBackendDown = errors.New("backend down")
+
+// failingAdapter is a ContextAdapter whose backend is unreachable.
+type failingAdapter struct {
+	adapterMock
+	err error
+}
+
+func (a *failingAdapter) GetContext(ctx context.Context, key uint64) ([]byte, bool, error) {
+	if a.err != nil {
+		return nil, false, a.err
+	}
+	b, ok := a.Get(key)
+	return b, ok, nil
+}
+
+func (a *failingAdapter) SetContext(ctx context.Context, key uint64, response []byte, expiration time.Time) error {
+	if a.err != nil {
+		return a.err
+	}
+	a.Set(key, response, expiration)
+	return nil
+}
+
+func (a *failingAdapter) ReleaseContext(ctx context.Context, key uint64) error {
+	if a.err != nil {
+		return a.err
+	}
+	a.Release(key)
+	return nil
+}
+
+// contextOnly implements ContextAdapter and nothing else. Like the redis
+// adapter, it fails calls whose context is done.
+type contextOnly struct {
+	store map[uint64][]byte
+}
+
+func (a *contextOnly) GetContext(ctx context.Context, key uint64) ([]byte, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+	b, ok := a.store[key]
+	return b, ok, nil
+}
+
+func (a *contextOnly) SetContext(ctx context.Context, key uint64, response []byte, expiration time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	a.store[key] = response
+	return nil
+}
+
+func (a *contextOnly) ReleaseContext(ctx context.Context, key uint64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	delete(a.store, key)
+	return nil
+}
+
+func TestClientWithContextAdapter(t *testing.T) {
+	adapter := &contextOnly{store: map[uint64][]byte{}}
+	recorder := &eventRecorder{}
+	client, err := NewClient(
+		ClientWithContextAdapter(adapter),
+		ClientWithTTL(time.Minute),
+		ClientWithObserver(recorder.observe),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte("origin"))
+	}))
+	for i := 0; i < 2; i++ {
+		if got := get(handler, "http://x/ctx"); got != "origin" {
+			t.Fatalf("body = %q, want origin", got)
+		}
+	}
+	if calls != 1 || len(adapter.store) != 1 {
+		t.Errorf("origin called %d times with %d entries stored, want 1 and 1", calls, len(adapter.store))
+	}
+
+	// A lookup failing because the client went away is a miss, not an
+	// adapter error.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	recorder.events = nil
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://x/gone", nil).WithContext(ctx))
+	for _, e := range recorder.events {
+		if e.Type == CacheEventError {
+			t.Errorf("error event %+v for a canceled request", e)
+		}
+	}
+	if len(recorder.events) == 0 || recorder.events[0].Type != CacheEventMiss {
+		t.Errorf("events = %+v, want a miss first", recorder.events)
+	}
+
+	if _, err := NewClient(ClientWithContextAdapter(nil), ClientWithTTL(time.Minute)); err == nil {
+		t.Error("NewClient() with a nil context adapter error = nil, want error")
+	}
+	if got := ToAdapter(&failingAdapter{}); got == nil {
+		t.Error("ToAdapter() = nil")
+	} else if _, ok := got.(*failingAdapter); !ok {
+		t.Error("ToAdapter() wrapped an adapter that already implements Adapter")
+	}
+}
+
+// When the adapter fails, the middleware must report the error and serve
+// the request from the origin instead of treating it as a plain miss.
+func TestMiddlewareReportsAdapterErrors(t *testing.T) {
+	adapter := &failingAdapter{adapterMock: adapterMock{store: map[uint64][]byte{}}, err: errBackendDown}
+	recorder := &eventRecorder{}
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithPurge(),
+		ClientWithObserver(recorder.observe),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("origin"))
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://x/down", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "origin" {
+		t.Fatalf("response = %d %q, want the origin response", w.Code, w.Body.String())
+	}
+
+	var types []CacheEventType
+	for _, e := range recorder.events {
+		types = append(types, e.Type)
+		if e.Type == CacheEventError && (e.Reason != ReasonAdapterError || !errors.Is(e.Err, errBackendDown)) {
+			t.Errorf("error event = %+v, want adapter-error wrapping the backend error", e)
+		}
+	}
+	if len(types) != 2 || types[0] != CacheEventError || types[1] != CacheEventError {
+		t.Errorf("events = %v, want an error for the lookup and one for the store", types)
+	}
+
+	if _, err := client.Lookup(context.Background(), httptest.NewRequest(http.MethodGet, "http://x/down", nil)); !errors.Is(err, errBackendDown) {
+		t.Errorf("Lookup() error = %v, want the backend error", err)
+	}
+	if err := client.Store(context.Background(), httptest.NewRequest(http.MethodGet, "http://x/down", nil), http.StatusOK, nil, nil, 0); !errors.Is(err, errBackendDown) {
+		t.Errorf("Store() error = %v, want the backend error", err)
+	}
+	if err := client.Drop(httptest.NewRequest(http.MethodGet, "http://x/down", nil)); !errors.Is(err, errBackendDown) {
+		t.Errorf("Drop() error = %v, want the backend error", err)
+	}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("PURGE", "http://x/down", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("PURGE status = %d, want 503", w.Code)
+	}
+
+	adapter.err = nil
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://x/up", nil))
+	if _, err := client.Lookup(context.Background(), httptest.NewRequest(http.MethodGet, "http://x/up", nil)); err != nil {
+		t.Errorf("Lookup() error = %v once the backend is back", err)
+	}
+}
+
+// An adapter refusing calls must make the middleware bypass the cache
+// without reporting errors.
+func TestMiddlewareBypassesUnavailableAdapter(t *testing.T) {
+	adapter := &failingAdapter{
+		adapterMock: adapterMock{store: map[uint64][]byte{}},
+		err:         fmt.Errorf("circuit open: %w", ErrAdapterUnavailable),
+	}
+	recorder := &eventRecorder{}
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithObserver(recorder.observe),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("origin"))
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://x/open", nil))
+	if w.Body.String() != "origin" {
+		t.Fatalf("body = %q, want the origin response", w.Body.String())
+	}
+	if len(recorder.events) != 1 || recorder.events[0].Type != CacheEventBypass || recorder.events[0].Reason != ReasonUnavailable {
+		t.Fatalf("events = %+v, want a single unavailable bypass", recorder.events)
+	}
+	if got := client.Stats().Bypasses[ReasonUnavailable]; got != 1 {
+		t.Errorf("unavailable bypasses = %d, want 1", got)
+	}
+}
+
+func TestToContextAdapter(t *testing.T) {
+	adapter := &adapterMock{store: map[uint64][]byte{}}
+	ca := ToContextAdapter(adapter)
+	if err := ca.SetContext(context.Background(), 1, []byte("v"), time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if b, ok, err := ca.GetContext(context.Background(), 1); err != nil || !ok || string(b) != "v" {
+		t.Fatalf("GetContext() = %q, %v, %v; want v", b, ok, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := ca.ReleaseContext(ctx, 1); err != context.Canceled {
+		t.Errorf("ReleaseContext() with a canceled context error = %v, want context.Canceled", err)
+	}
+	if _, ok := adapter.Get(1); !ok {
+		t.Error("ReleaseContext() with a canceled context released the entry")
+	}
+
+	failing := &failingAdapter{}
+	if got := ToContextAdapter(failing); got != ContextAdapter(failing) {
+		t.Error("ToContextAdapter() wrapped an adapter that already implements ContextAdapter")
+	}
+}
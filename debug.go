@@ -0,0 +1,96 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cache
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Debug response headers added by ClientWithDebugHeaders.
+const (
+	debugStatusHeader      = "X-Cache"
+	debugKeyHeader         = "X-Cache-Key"
+	debugFingerprintHeader = "X-Cache-Fingerprint"
+	debugTTLHeader         = "X-Cache-TTL"
+	debugReasonHeader      = "X-Cache-Reason"
+	debugSkipHeader        = "X-Cache-Store-Skipped"
+)
+
+// Values of the X-Cache debug header.
+const (
+	debugHit    = "HIT"
+	debugMiss   = "MISS"
+	debugStale  = "STALE"
+	debugBypass = "BYPASS"
+)
+
+// debugging reports whether r asked for debug headers.
+func (c *Client) debugging(r *http.Request) bool {
+	if c.debugHeader == "" {
+		return false
+	}
+	v := r.Header.Get(c.debugHeader)
+	return v != "" && subtle.ConstantTimeCompare([]byte(v), []byte(c.debugSecret)) == 1
+}
+
+// setDebugHeaders writes the X-Cache status of a request that has a
+// cache key. reason is omitted when empty.
+func setDebugHeaders(h http.Header, status string, key uint64, fingerprint []byte, reason string) {
+	h.Set(debugStatusHeader, status)
+	h.Set(debugKeyHeader, KeyAsString(key))
+	h.Set(debugFingerprintHeader, hex.EncodeToString(fingerprint))
+	if reason != "" {
+		h.Set(debugReasonHeader, reason)
+	}
+}
+
+// setDebugTTL writes the remaining TTL of a served entry in whole
+// seconds, negative once it has expired. Entries without expiration have
+// no TTL header.
+func setDebugTTL(h http.Header, expiration time.Time) {
+	if expiration.IsZero() {
+		return
+	}
+	h.Set(debugTTLHeader, strconv.FormatInt(int64(time.Until(expiration)/time.Second), 10))
+}
+
+// setDebugBypass writes the X-Cache status of a request that skipped the
+// cache altogether.
+func setDebugBypass(h http.Header, reason string) {
+	h.Set(debugStatusHeader, debugBypass)
+	h.Set(debugReasonHeader, reason)
+}
+
+// setDebugSkipped reports why a response was not stored. It is a no-op
+// for stored responses.
+func setDebugSkipped(h http.Header, reason string) {
+	if reason != "" {
+		h.Set(debugSkipHeader, reason)
+	}
+}
@@ -0,0 +1,169 @@
+package cache
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newDebugClient(t *testing.T, opts ...ClientOption) *Client {
+	t.Helper()
+	client, err := NewClient(append([]ClientOption{
+		ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+		ClientWithTTL(1 * time.Minute),
+		ClientWithDebugHeaders("X-Cache-Debug", "s3cret"),
+	}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func debugRequest(method, url string) *http.Request {
+	r := httptest.NewRequest(method, url, nil)
+	r.Header.Set("X-Cache-Debug", "s3cret")
+	return r
+}
+
+func TestClientWithDebugHeadersReportsMissThenHit(t *testing.T) {
+	const url = "http://x/debug"
+	client := newDebugClient(t)
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, debugRequest(http.MethodGet, url))
+	if got := w.Header().Get("X-Cache"); got != "MISS" {
+		t.Fatalf("X-Cache = %q, want MISS", got)
+	}
+	if got := w.Header().Get("X-Cache-Reason"); got != "not-found" {
+		t.Errorf("X-Cache-Reason = %q, want not-found", got)
+	}
+	if got, want := w.Header().Get("X-Cache-Key"), KeyAsString(generateKey(url)); got != want {
+		t.Errorf("X-Cache-Key = %q, want %q", got, want)
+	}
+	if got, want := w.Header().Get("X-Cache-Fingerprint"), hex.EncodeToString(canonicalFingerprint(url, nil, nil, nil)); got != want {
+		t.Errorf("X-Cache-Fingerprint = %q, want %q", got, want)
+	}
+	if got := w.Header().Get("X-Cache-Store-Skipped"); got != "" {
+		t.Errorf("X-Cache-Store-Skipped = %q for a stored response", got)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, debugRequest(http.MethodGet, url))
+	if got := w.Header().Get("X-Cache"); got != "HIT" {
+		t.Fatalf("X-Cache = %q, want HIT", got)
+	}
+	if got := w.Header().Get("X-Cache-TTL"); got != "59" && got != "60" {
+		t.Errorf("X-Cache-TTL = %q, want about 60", got)
+	}
+}
+
+func TestClientWithDebugHeadersRequiresSecret(t *testing.T) {
+	client := newDebugClient(t)
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	for _, secret := range []string{"", "wrong"} {
+		r := httptest.NewRequest(http.MethodGet, "http://x/debug-secret", nil)
+		if secret != "" {
+			r.Header.Set("X-Cache-Debug", secret)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		for k := range w.Header() {
+			if strings.HasPrefix(k, "X-Cache") {
+				t.Errorf("secret %q: response has debug header %s", secret, k)
+			}
+		}
+	}
+}
+
+func TestClientWithDebugHeadersReportsStoreSkipReasons(t *testing.T) {
+	client := newDebugClient(t,
+		ClientWithMaxBodySize(4),
+		ClientWithSkipCacheResponseHeader("X-No-Cache"),
+	)
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    string
+		trailer bool
+	}{
+		{"status", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}, "status-code", false},
+		{"skip header", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-No-Cache", "1")
+			w.Write([]byte("ok"))
+		}, "skip-header", false},
+		{"max body on first write", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("way too long"))
+		}, "max-body", false},
+		{"max body after header", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+			w.Write([]byte("way too long"))
+		}, "max-body", true},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			client.Middleware(tt.handler).ServeHTTP(w, debugRequest(http.MethodGet, fmt.Sprintf("http://x/skip/%d", i)))
+			res := w.Result()
+			got := res.Header.Get("X-Cache-Store-Skipped")
+			if tt.trailer {
+				got = res.Trailer.Get("X-Cache-Store-Skipped")
+			}
+			if got != tt.want {
+				t.Errorf("store skipped = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientWithDebugHeadersReportsBypass(t *testing.T) {
+	client := newDebugClient(t,
+		ClientWithSkipCacheURIPathRegex(regexp.MustCompile("^/admin")),
+		ClientWithRespectCacheControl(),
+	)
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	noStore := debugRequest(http.MethodGet, "http://x/no-store")
+	noStore.Header.Set("Cache-Control", "no-store")
+	tests := []struct {
+		req  *http.Request
+		want string
+	}{
+		{debugRequest(http.MethodPut, "http://x/put"), "method"},
+		{debugRequest(http.MethodGet, "http://x/admin"), "path"},
+		{noStore, "request-no-store"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, tt.req)
+		if got := w.Header().Get("X-Cache"); got != "BYPASS" {
+			t.Errorf("%s %s: X-Cache = %q, want BYPASS", tt.req.Method, tt.req.URL, got)
+		}
+		if got := w.Header().Get("X-Cache-Reason"); got != tt.want {
+			t.Errorf("%s %s: X-Cache-Reason = %q, want %q", tt.req.Method, tt.req.URL, got, tt.want)
+		}
+	}
+}
+
+func TestClientWithDebugHeadersRejectsEmptySettings(t *testing.T) {
+	if _, err := NewClient(
+		ClientWithAdapter(&adapterMock{}),
+		ClientWithTTL(time.Minute),
+		ClientWithDebugHeaders("X-Cache-Debug", ""),
+	); err == nil {
+		t.Error("NewClient() error = nil, want error")
+	}
+}
@@ -0,0 +1,142 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type eventRecorder struct {
+	sync.Mutex
+	events []CacheEvent
+}
+
+func (e *eventRecorder) observe(event CacheEvent) {
+	e.Lock()
+	defer e.Unlock()
+	e.events = append(e.events, event)
+}
+
+func (e *eventRecorder) last(t *testing.T, eventType CacheEventType) CacheEvent {
+	t.Helper()
+	e.Lock()
+	defer e.Unlock()
+	for i := len(e.events) - 1; i >= 0; i-- {
+		if e.events[i].Type == eventType {
+			return e.events[i]
+		}
+	}
+	t.Fatalf("no %s event in %v", eventType, e.events)
+	return CacheEvent{}
+}
+
+func TestObserverReceivesDetailedEvents(t *testing.T) {
+	const url = "http://x/events"
+	adapter := &adapterMock{store: map[uint64][]byte{}}
+	recorder := &eventRecorder{}
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithMethods([]string{http.MethodGet, http.MethodPost}),
+		ClientWithObserver(recorder.observe),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("status") != "" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write([]byte("body"))
+	}))
+	serve := func(method, target string) {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, target, nil))
+	}
+
+	serve(http.MethodGet, url)
+	if miss := recorder.last(t, CacheEventMiss); miss.Reason != ReasonNotFound {
+		t.Errorf("miss reason = %q, want %q", miss.Reason, ReasonNotFound)
+	}
+	store := recorder.last(t, CacheEventStore)
+	if store.Size != 4 || store.TTL <= 59*time.Second || store.Duration <= 0 {
+		t.Errorf("store event = %+v, want size 4, a 1m TTL and a duration", store)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	serve(http.MethodGet, url)
+	hit := recorder.last(t, CacheEventHit)
+	if hit.Size != 4 || hit.Age < 5*time.Millisecond || hit.TTL <= 0 || hit.StatusCode != http.StatusOK {
+		t.Errorf("hit event = %+v, want size 4, an age, a TTL and status 200", hit)
+	}
+
+	serve(http.MethodGet, url+"?status=500")
+	if skip := recorder.last(t, CacheEventSkip); skip.Reason != ReasonStatusCode || skip.StatusCode != http.StatusInternalServerError {
+		t.Errorf("skip event = %+v, want status-code and 500", skip)
+	}
+
+	serve(http.MethodPut, url)
+	if bypass := recorder.last(t, CacheEventBypass); bypass.Reason != ReasonMethod {
+		t.Errorf("bypass reason = %q, want %q", bypass.Reason, ReasonMethod)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, url, errReader(0))
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if e := recorder.last(t, CacheEventError); e.Reason != ReasonKeyError || e.Err == nil {
+		t.Errorf("error event = %+v, want key-error with an error", e)
+	}
+
+	adapter.Set(generateKey(url), []byte("garbage"), time.Now().Add(time.Minute))
+	serve(http.MethodGet, url)
+	if corrupt := recorder.last(t, CacheEventCorrupt); corrupt.Err == nil || corrupt.Size != len("garbage") {
+		t.Errorf("corrupt event = %+v, want a decode error and the entry size", corrupt)
+	}
+
+	adapter.Set(generateKey(url), Response{Value: []byte("x"), CanonicalKey: []byte("other")}.Bytes(), time.Now().Add(time.Minute))
+	serve(http.MethodGet, url)
+	if collision := recorder.last(t, CacheEventCollision); collision.Reason != ReasonCollision {
+		t.Errorf("collision reason = %q, want %q", collision.Reason, ReasonCollision)
+	}
+
+	stats := client.Stats()
+	if stats.Events[CacheEventCorrupt] != 1 || stats.Events[CacheEventCollision] != 1 || stats.Events[CacheEventSkip] != 1 {
+		t.Errorf("Stats().Events = %v, want 1 corrupt, 1 collision and 1 skip", stats.Events)
+	}
+}
+
+// notifyingAdapter reports an eviction for every Release.
+type notifyingAdapter struct {
+	adapterMock
+	notify func(CacheEvent)
+}
+
+func (a *notifyingAdapter) Notify(fn func(CacheEvent)) {
+	a.notify = fn
+}
+
+func (a *notifyingAdapter) Release(key uint64) {
+	a.adapterMock.Release(key)
+	a.notify(CacheEvent{Type: CacheEventEviction, Key: key, Reason: ReasonCapacity})
+}
+
+func TestAdapterNotifierEventsReachObserver(t *testing.T) {
+	adapter := &notifyingAdapter{adapterMock: adapterMock{store: map[uint64][]byte{}}}
+	recorder := &eventRecorder{}
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithObserver(recorder.observe),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adapter.Release(42)
+	if e := recorder.last(t, CacheEventEviction); e.Key != 42 || e.Request != nil {
+		t.Errorf("eviction event = %+v, want key 42 without request", e)
+	}
+	if n := client.Stats().Events[CacheEventEviction]; n != 1 {
+		t.Errorf("Stats().Events[eviction] = %d, want 1", n)
+	}
+}
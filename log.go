@@ -0,0 +1,153 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+)
+
+// DefaultLogLevels are the levels ClientWithLogger logs events at when
+// LogOptions.Levels does not set them: problems that cost a hit are
+// warnings, errors are errors, the rest is debug.
+var DefaultLogLevels = map[CacheEventType]slog.Level{
+	CacheEventHit:       slog.LevelDebug,
+	CacheEventMiss:      slog.LevelDebug,
+	CacheEventStale:     slog.LevelDebug,
+	CacheEventRefresh:   slog.LevelDebug,
+	CacheEventStore:     slog.LevelDebug,
+	CacheEventPurge:     slog.LevelInfo,
+	CacheEventBypass:    slog.LevelDebug,
+	CacheEventCollision: slog.LevelWarn,
+	CacheEventCorrupt:   slog.LevelWarn,
+	CacheEventSkip:      slog.LevelDebug,
+	CacheEventError:     slog.LevelError,
+	CacheEventEviction:  slog.LevelDebug,
+	CacheEventCircuit:   slog.LevelWarn,
+}
+
+// LogOptions configures ClientWithLogger.
+type LogOptions struct {
+	// Levels overrides DefaultLogLevels for the given event types.
+	Levels map[CacheEventType]slog.Level
+
+	// HitSampling logs one hit out of HitSampling. 0 and 1 log every
+	// hit.
+	HitSampling int
+}
+
+// eventLogger writes cache events as slog records.
+type eventLogger struct {
+	logger      *slog.Logger
+	levels      map[CacheEventType]slog.Level
+	hitSampling uint64
+	hits        atomic.Uint64
+}
+
+// ClientWithLogger logs a structured record for every cache event with
+// logger, next to the observer set by ClientWithObserver (and through the
+// same queue with ClientWithAsyncObserver). Records carry the event,
+// key, method, path, status, TTL, age, size, reason, origin duration and
+// error when they apply, and the request context when there is one.
+// Optional setting.
+func ClientWithLogger(logger *slog.Logger, opts LogOptions) ClientOption {
+	return func(c *Client) error {
+		if logger == nil {
+			return errors.New("cache client logger is not set")
+		}
+		if opts.HitSampling < 0 {
+			return errors.New("cache client logger hit sampling must not be negative")
+		}
+		l := &eventLogger{
+			logger:      logger,
+			levels:      make(map[CacheEventType]slog.Level, len(DefaultLogLevels)),
+			hitSampling: uint64(opts.HitSampling),
+		}
+		for t, level := range DefaultLogLevels {
+			l.levels[t] = level
+		}
+		for t, level := range opts.Levels {
+			l.levels[t] = level
+		}
+		c.logger = l
+		return nil
+	}
+}
+
+func (l *eventLogger) log(event CacheEvent) {
+	level, ok := l.levels[event.Type]
+	if !ok {
+		level = slog.LevelDebug
+	}
+	ctx := context.Background()
+	if event.Request != nil {
+		ctx = event.Request.Context()
+	}
+	if !l.logger.Enabled(ctx, level) {
+		return
+	}
+	if event.Type == CacheEventHit && l.hitSampling > 1 && (l.hits.Add(1)-1)%l.hitSampling != 0 {
+		return
+	}
+
+	attrs := make([]slog.Attr, 0, 12)
+	attrs = append(attrs, slog.String("event", string(event.Type)))
+	if event.Key != 0 {
+		attrs = append(attrs, slog.String("key", KeyAsString(event.Key)))
+	}
+	if event.WideKey != "" {
+		attrs = append(attrs, slog.String("wide_key", event.WideKey))
+	}
+	if event.Request != nil {
+		attrs = append(attrs,
+			slog.String("method", event.Request.Method),
+			slog.String("path", event.Request.URL.Path),
+		)
+	}
+	if event.StatusCode != 0 {
+		attrs = append(attrs, slog.Int("status", event.StatusCode))
+	}
+	if event.Reason != "" {
+		attrs = append(attrs, slog.String("reason", event.Reason))
+	}
+	if event.TTL != 0 {
+		attrs = append(attrs, slog.Duration("ttl", event.TTL))
+	}
+	if event.Age != 0 {
+		attrs = append(attrs, slog.Duration("age", event.Age))
+	}
+	if event.Size != 0 {
+		attrs = append(attrs, slog.Int("size", event.Size))
+	}
+	if event.Duration != 0 {
+		attrs = append(attrs, slog.Duration("duration", event.Duration))
+	}
+	if event.Err != nil {
+		attrs = append(attrs, slog.Any("error", event.Err))
+	}
+	l.logger.LogAttrs(ctx, level, "http-cache "+string(event.Type), attrs...)
+}
@@ -0,0 +1,114 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func decodeLogRecords(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		record := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestClientWithLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	var observed int
+	client, err := NewClient(
+		ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+		ClientWithTTL(1*time.Minute),
+		ClientWithObserver(func(CacheEvent) { observed++ }),
+		ClientWithLogger(logger, LogOptions{
+			Levels:      map[CacheEventType]slog.Level{CacheEventStore: slog.LevelInfo},
+			HitSampling: 2,
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("body"))
+	}))
+	for i := 0; i < 5; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://x/logged?a=1", nil))
+	}
+
+	// miss, store and 4 hits, of which 2 are sampled out.
+	if observed != 6 {
+		t.Errorf("observer received %d events, want 6", observed)
+	}
+	records := decodeLogRecords(t, &buf)
+	var events []string
+	for _, r := range records {
+		events = append(events, r["event"].(string))
+	}
+	if got := strings.Join(events, ","); got != "miss,store,hit,hit" {
+		t.Fatalf("logged events = %s, want miss,store,hit,hit", got)
+	}
+
+	miss, store := records[0], records[1]
+	if miss["level"] != "DEBUG" || miss["reason"] != ReasonNotFound || miss["path"] != "/logged" || miss["method"] != http.MethodGet {
+		t.Errorf("miss record = %v", miss)
+	}
+	if store["level"] != "INFO" || store["msg"] != "http-cache store" || store["status"] != float64(200) || store["size"] != float64(4) {
+		t.Errorf("store record = %v", store)
+	}
+	if _, ok := store["ttl"]; !ok {
+		t.Errorf("store record has no ttl: %v", store)
+	}
+}
+
+func TestClientWithLoggerSkipsDisabledLevels(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	client, err := NewClient(
+		ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+		ClientWithTTL(1*time.Minute),
+		ClientWithLogger(logger, LogOptions{}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("body"))
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://x/quiet", nil))
+	if buf.Len() != 0 {
+		t.Errorf("logged %q at warn level, want nothing", buf.String())
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "http://x/quiet", nil)
+	client.emit(CacheEvent{Type: CacheEventError, Request: r, Reason: ReasonKeyError, Err: errors.New("boom")})
+	if !strings.Contains(buf.String(), "level=ERROR") || !strings.Contains(buf.String(), "error=boom") {
+		t.Errorf("error record = %q", buf.String())
+	}
+}
+
+func TestClientWithLoggerRejectsInvalidSettings(t *testing.T) {
+	for _, opt := range []ClientOption{
+		ClientWithLogger(nil, LogOptions{}),
+		ClientWithLogger(slog.Default(), LogOptions{HitSampling: -1}),
+	} {
+		if _, err := NewClient(ClientWithAdapter(&adapterMock{}), ClientWithTTL(time.Minute), opt); err == nil {
+			t.Error("NewClient() error = nil, want error")
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLookupReturnsEntryMetadata(t *testing.T) {
+	const url = "http://x/lookup?b=2&a=1"
+	adapter := &touchingAdapter{store: map[uint64][]byte{}}
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("cached"))
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, url, nil))
+
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	entry, err := client.Lookup(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.URL.RawQuery != "b=2&a=1" {
+		t.Errorf("Lookup mutated the request query to %q", req.URL.RawQuery)
+	}
+	if string(entry.Response.Value) != "cached" {
+		t.Errorf("Value = %q, want cached", string(entry.Response.Value))
+	}
+	if entry.StatusCode != http.StatusAccepted {
+		t.Errorf("StatusCode = %d, want 202", entry.StatusCode)
+	}
+	if entry.Key != generateKey("http://x/lookup?a=1&b=2") {
+		t.Errorf("Key = %d, want the middleware key", entry.Key)
+	}
+	if entry.TTL <= 0 || entry.TTL > time.Minute {
+		t.Errorf("TTL = %v, want within (0, 1m]", entry.TTL)
+	}
+	if entry.Age < 0 || entry.Age > time.Second {
+		t.Errorf("Age = %v, want close to 0", entry.Age)
+	}
+	if entry.Stale {
+		t.Error("Stale = true, want false")
+	}
+	if !entry.FingerprintMatch {
+		t.Error("FingerprintMatch = false, want true")
+	}
+	if touches := atomic.LoadInt64(&adapter.touches); touches != 0 {
+		t.Errorf("Lookup recorded %d accesses, want 0", touches)
+	}
+	if sets := atomic.LoadInt64(&adapter.setCalls); sets != 1 {
+		t.Errorf("adapter Set called %d times, want 1 (the store only)", sets)
+	}
+}
+
+func TestLookupReportsStaleAndCollidingEntries(t *testing.T) {
+	const url = "http://x/lookup-stale"
+	adapter := &adapterMock{
+		store: map[uint64][]byte{
+			generateKey(url): Response{
+				Value:        []byte("old"),
+				Expiration:   time.Now().Add(-time.Second),
+				CanonicalKey: []byte("someone else"),
+			}.Bytes(),
+		},
+	}
+	client, err := NewClient(ClientWithAdapter(adapter), ClientWithTTL(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := client.Lookup(context.Background(), httptest.NewRequest(http.MethodGet, url, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !entry.Stale || entry.TTL >= 0 {
+		t.Errorf("Stale = %v, TTL = %v; want stale with negative TTL", entry.Stale, entry.TTL)
+	}
+	if entry.FingerprintMatch {
+		t.Error("FingerprintMatch = true for a colliding entry")
+	}
+}
+
+func TestLookupReturnsErrNotCached(t *testing.T) {
+	client, err := NewClient(
+		ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+		ClientWithTTL(time.Minute),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.Lookup(context.Background(), httptest.NewRequest(http.MethodGet, "http://x/none", nil))
+	if !errors.Is(err, ErrNotCached) {
+		t.Fatalf("Lookup() error = %v, want ErrNotCached", err)
+	}
+}
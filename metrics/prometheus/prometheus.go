@@ -0,0 +1,243 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package prometheus exports http-cache middleware and adapter metrics to
+// Prometheus. A Collector receives middleware events as a
+// cache.Observer and reads gauges from the client it is attached to:
+//
+//	collector, err := prometheus.New("api", prometheus.WithRoute(route))
+//	client, err := cache.NewClient(
+//		cache.ClientWithAdapter(adapter),
+//		cache.ClientWithTTL(10*time.Minute),
+//		cache.ClientWithObserver(collector.Observe),
+//	)
+//	collector.Attach(client)
+//	registry.MustRegister(collector)
+//
+// Every metric carries a client label with the collector name, so several
+// clients can share a registry.
+package prometheus
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	cache "github.com/victorspringer/http-cache"
+)
+
+const namespace = "http_cache"
+
+// DefaultOriginBuckets are the origin duration histogram buckets, in
+// seconds.
+var DefaultOriginBuckets = prom.DefBuckets
+
+// DefaultSizeBuckets are the body size histogram buckets, in bytes: 256B
+// to 16MiB.
+var DefaultSizeBuckets = prom.ExponentialBuckets(256, 4, 9)
+
+// Collector is a prometheus.Collector for a cache.Client.
+type Collector struct {
+	name          string
+	route         func(*http.Request) string
+	originBuckets []float64
+	sizeBuckets   []float64
+
+	events         *prom.CounterVec
+	originDuration *prom.HistogramVec
+	bodySize       *prom.HistogramVec
+
+	waitersDesc   *prom.Desc
+	droppedDesc   *prom.Desc
+	entriesDesc   *prom.Desc
+	bytesDesc     *prom.Desc
+	evictionsDesc *prom.Desc
+
+	mu     sync.RWMutex
+	client *cache.Client
+}
+
+// Option is used to set Collector settings.
+type Option func(c *Collector) error
+
+// New initializes a Collector for the client called name.
+func New(name string, opts ...Option) (*Collector, error) {
+	if name == "" {
+		return nil, errors.New("prometheus collector name is not set")
+	}
+	c := &Collector{
+		name:          name,
+		originBuckets: DefaultOriginBuckets,
+		sizeBuckets:   DefaultSizeBuckets,
+	}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+
+	labels := prom.Labels{"client": name}
+	var routeLabel []string
+	if c.route != nil {
+		routeLabel = []string{"route"}
+	}
+	c.events = prom.NewCounterVec(prom.CounterOpts{
+		Namespace:   namespace,
+		Name:        "events_total",
+		Help:        "Cache middleware and adapter events by type.",
+		ConstLabels: labels,
+	}, append([]string{"event"}, routeLabel...))
+	c.originDuration = prom.NewHistogramVec(prom.HistogramOpts{
+		Namespace:   namespace,
+		Name:        "origin_duration_seconds",
+		Help:        "Time the origin handler took to produce responses, by outcome (store or skip).",
+		ConstLabels: labels,
+		Buckets:     c.originBuckets,
+	}, append([]string{"outcome"}, routeLabel...))
+	c.bodySize = prom.NewHistogramVec(prom.HistogramOpts{
+		Namespace:   namespace,
+		Name:        "body_size_bytes",
+		Help:        "Size of the response bodies served from cache (hit) and stored (store).",
+		ConstLabels: labels,
+		Buckets:     c.sizeBuckets,
+	}, append([]string{"event"}, routeLabel...))
+
+	c.waitersDesc = prom.NewDesc(namespace+"_singleflight_waiters",
+		"Requests currently waiting for a coalesced origin request.", nil, labels)
+	c.droppedDesc = prom.NewDesc(namespace+"_observer_dropped_events_total",
+		"Events the asynchronous observer queue could not deliver.", nil, labels)
+	c.entriesDesc = prom.NewDesc(namespace+"_adapter_entries",
+		"Entries held by the adapter.", nil, labels)
+	c.bytesDesc = prom.NewDesc(namespace+"_adapter_bytes",
+		"Total size of the entries held by the adapter.", nil, labels)
+	c.evictionsDesc = prom.NewDesc(namespace+"_adapter_evictions_total",
+		"Entries the adapter released to make room for others.", nil, labels)
+	return c, nil
+}
+
+// WithRoute adds a route label to the event, origin duration and body
+// size metrics, set to what route returns for the request. route must
+// return a bounded set of values, such as the pattern a router matched,
+// never the raw path.
+func WithRoute(route func(*http.Request) string) Option {
+	return func(c *Collector) error {
+		if route == nil {
+			return errors.New("prometheus collector route function is not set")
+		}
+		c.route = route
+		return nil
+	}
+}
+
+// WithOriginBuckets sets the origin duration histogram buckets, in
+// seconds.
+func WithOriginBuckets(buckets []float64) Option {
+	return func(c *Collector) error {
+		if len(buckets) == 0 {
+			return errors.New("prometheus collector origin buckets are not set")
+		}
+		c.originBuckets = buckets
+		return nil
+	}
+}
+
+// WithSizeBuckets sets the body size histogram buckets, in bytes.
+func WithSizeBuckets(buckets []float64) Option {
+	return func(c *Collector) error {
+		if len(buckets) == 0 {
+			return errors.New("prometheus collector size buckets are not set")
+		}
+		c.sizeBuckets = buckets
+		return nil
+	}
+}
+
+// Observe records a middleware event. Pass it to cache.ClientWithObserver,
+// or call it from your own observer.
+func (c *Collector) Observe(event cache.CacheEvent) {
+	var labels []string
+	if c.route != nil {
+		route := ""
+		if event.Request != nil {
+			route = c.route(event.Request)
+		}
+		labels = []string{route}
+	}
+	eventLabels := append([]string{string(event.Type)}, labels...)
+
+	c.events.WithLabelValues(eventLabels...).Inc()
+	switch event.Type {
+	case cache.CacheEventHit, cache.CacheEventStore:
+		c.bodySize.WithLabelValues(eventLabels...).Observe(float64(event.Size))
+	}
+	// Store and skip events carry the origin duration: observing both
+	// keeps the slow or failing responses that are not cached in sight.
+	if event.Duration > 0 {
+		c.originDuration.WithLabelValues(eventLabels...).Observe(event.Duration.Seconds())
+	}
+}
+
+// Attach makes the collector report the singleflight waiters, the events
+// dropped by an asynchronous observer and, if its adapter implements
+// cache.AdapterStats, the adapter usage of client.
+func (c *Collector) Attach(client *cache.Client) {
+	c.mu.Lock()
+	c.client = client
+	c.mu.Unlock()
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prom.Desc) {
+	c.events.Describe(ch)
+	c.originDuration.Describe(ch)
+	c.bodySize.Describe(ch)
+	ch <- c.waitersDesc
+	ch <- c.droppedDesc
+	ch <- c.entriesDesc
+	ch <- c.bytesDesc
+	ch <- c.evictionsDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prom.Metric) {
+	c.events.Collect(ch)
+	c.originDuration.Collect(ch)
+	c.bodySize.Collect(ch)
+
+	c.mu.RLock()
+	client := c.client
+	c.mu.RUnlock()
+	if client == nil {
+		return
+	}
+	stats := client.Stats()
+	ch <- prom.MustNewConstMetric(c.waitersDesc, prom.GaugeValue, float64(stats.SingleflightWaiters))
+	ch <- prom.MustNewConstMetric(c.droppedDesc, prom.CounterValue, float64(stats.DroppedEvents))
+	if stats.Adapter != nil {
+		ch <- prom.MustNewConstMetric(c.entriesDesc, prom.GaugeValue, float64(stats.Adapter.Entries))
+		ch <- prom.MustNewConstMetric(c.bytesDesc, prom.GaugeValue, float64(stats.Adapter.Bytes))
+		ch <- prom.MustNewConstMetric(c.evictionsDesc, prom.CounterValue, float64(stats.Adapter.Evictions))
+	}
+}
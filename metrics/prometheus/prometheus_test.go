@@ -0,0 +1,98 @@
+package prometheus
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	cache "github.com/victorspringer/http-cache"
+	"github.com/victorspringer/http-cache/adapter/memory"
+)
+
+func TestCollector(t *testing.T) {
+	collector, err := New("test", WithRoute(func(r *http.Request) string {
+		return strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)[0]
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	adapter, err := memory.NewAdapter(memory.AdapterWithAlgorithm(memory.LRU), memory.AdapterWithCapacity(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := cache.NewClient(
+		cache.ClientWithAdapter(adapter),
+		cache.ClientWithTTL(time.Minute),
+		cache.ClientWithObserver(collector.Observe),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collector.Attach(client)
+
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond)
+		if strings.HasPrefix(r.URL.Path, "/err/") {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write([]byte("body"))
+	}))
+	for _, target := range []string{"/a/1", "/a/1", "/a/1", "/b/1", "/err/1"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/a/1", nil))
+
+	tests := []struct {
+		event, route string
+		want         float64
+	}{
+		{"hit", "a", 2},
+		{"miss", "a", 1},
+		{"store", "a", 1},
+		{"miss", "b", 1},
+		{"bypass", "a", 1},
+		{"skip", "err", 1},
+	}
+	for _, tt := range tests {
+		if got := testutil.ToFloat64(collector.events.WithLabelValues(tt.event, tt.route)); got != tt.want {
+			t.Errorf("events_total{event=%q,route=%q} = %v, want %v", tt.event, tt.route, got, tt.want)
+		}
+	}
+
+	registry := prom.NewPedanticRegistry()
+	if err := registry.Register(collector); err != nil {
+		t.Fatal(err)
+	}
+	expected := `
+# HELP http_cache_adapter_entries Entries held by the adapter.
+# TYPE http_cache_adapter_entries gauge
+http_cache_adapter_entries{client="test"} 2
+# HELP http_cache_singleflight_waiters Requests currently waiting for a coalesced origin request.
+# TYPE http_cache_singleflight_waiters gauge
+http_cache_singleflight_waiters{client="test"} 0
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "http_cache_adapter_entries", "http_cache_singleflight_waiters"); err != nil {
+		t.Error(err)
+	}
+	if n, err := testutil.GatherAndCount(registry, "http_cache_origin_duration_seconds", "http_cache_body_size_bytes"); err != nil || n != 6 {
+		t.Errorf("histogram series = %d (%v), want 6", n, err)
+	}
+	if n := testutil.CollectAndCount(collector.originDuration); n != 3 {
+		t.Errorf("origin duration series = %d, want 3 (store a, store b, skip err)", n)
+	}
+}
+
+func TestNewRejectsInvalidOptions(t *testing.T) {
+	if _, err := New(""); err == nil {
+		t.Error("New() without a name error = nil, want error")
+	}
+	for _, opt := range []Option{WithRoute(nil), WithOriginBuckets(nil), WithSizeBuckets(nil)} {
+		if _, err := New("test", opt); err == nil {
+			t.Error("New() error = nil, want error")
+		}
+	}
+}
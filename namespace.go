@@ -0,0 +1,84 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"strings"
+)
+
+// ClientWithNamespace mixes name and version into every cache key and
+// fingerprint, so clients with different namespaces can share an adapter
+// without colliding, and bumping version orphans the entries written
+// under the previous one: they are never served again and expire on
+// their own. Entries record the namespace name, and the admin handler
+// only lists, purges and flushes the entries of its own namespace.
+func ClientWithNamespace(name, version string) ClientOption {
+	return func(c *Client) error {
+		if name == "" {
+			return errors.New("cache client namespace name is empty")
+		}
+		if strings.ContainsRune(name, 0) || strings.ContainsRune(version, 0) {
+			return errors.New("cache client namespace contains a NUL byte")
+		}
+		c.namespace = name
+		c.namespaceVersion = version
+		return nil
+	}
+}
+
+// namespaced mixes the client namespace into a request key and
+// fingerprint.
+func (c *Client) namespaced(key uint64, fingerprint []byte) (uint64, []byte) {
+	if c.namespace == "" {
+		return key, fingerprint
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(c.namespace))
+	h.Write([]byte{0})
+	h.Write([]byte(c.namespaceVersion))
+	h.Write([]byte{0})
+	h.Write(binary.BigEndian.AppendUint64(nil, key))
+
+	s := sha256.New()
+	s.Write([]byte(c.namespace))
+	s.Write([]byte{0})
+	s.Write([]byte(c.namespaceVersion))
+	s.Write([]byte{0})
+	s.Write(fingerprint)
+
+	return h.Sum64(), s.Sum(nil)
+}
+
+// ownsEntry reports whether the admin handler may act on response:
+// without a namespace every entry, otherwise the entries stored under
+// the client's namespace name, whatever their version.
+func (c *Client) ownsEntry(response Response) bool {
+	return c.namespace == "" || response.Namespace == c.namespace
+}
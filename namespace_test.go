@@ -0,0 +1,103 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newNamespacedClient(t *testing.T, adapter Adapter, name, version string) (*Client, http.Handler, *int) {
+	t.Helper()
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(time.Minute),
+		ClientWithNamespace(name, version),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := new(int)
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		fmt.Fprintf(w, "%s@%s", name, version)
+	}))
+	return client, handler, calls
+}
+
+func get(handler http.Handler, url string) string {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+	return w.Body.String()
+}
+
+// Clients sharing an adapter must each see their own entries.
+func TestClientWithNamespaceSeparatesClients(t *testing.T) {
+	adapter := &adapterMock{store: map[uint64][]byte{}}
+	_, catalog, catalogCalls := newNamespacedClient(t, adapter, "catalog", "1")
+	_, search, searchCalls := newNamespacedClient(t, adapter, "search", "1")
+
+	for i := 0; i < 2; i++ {
+		if got := get(catalog, "http://x/items"); got != "catalog@1" {
+			t.Errorf("catalog body = %q", got)
+		}
+		if got := get(search, "http://x/items"); got != "search@1" {
+			t.Errorf("search body = %q", got)
+		}
+	}
+	if *catalogCalls != 1 || *searchCalls != 1 {
+		t.Errorf("origin calls = %d and %d, want 1 each", *catalogCalls, *searchCalls)
+	}
+	if len(adapter.store) != 2 {
+		t.Errorf("adapter holds %d entries, want 2", len(adapter.store))
+	}
+}
+
+func TestBumpingNamespaceVersionOrphansEntries(t *testing.T) {
+	adapter := &adapterMock{store: map[uint64][]byte{}}
+	_, v1, _ := newNamespacedClient(t, adapter, "catalog", "1")
+	get(v1, "http://x/items")
+
+	client, v2, calls := newNamespacedClient(t, adapter, "catalog", "2")
+	if got := get(v2, "http://x/items"); got != "catalog@2" || *calls != 1 {
+		t.Errorf("body = %q after %d origin calls, want a miss", got, *calls)
+	}
+	entry, err := client.Lookup(context.Background(), httptest.NewRequest(http.MethodGet, "http://x/items", nil))
+	if err != nil || entry.Response.Namespace != "catalog" {
+		t.Errorf("Lookup() = %+v, %v; want an entry of the catalog namespace", entry.Response, err)
+	}
+}
+
+func TestAdminOnlyFlushesItsNamespace(t *testing.T) {
+	adapter := &keyedAdapter{adapterMock{store: map[uint64][]byte{}}}
+	catalog, catalogHandler, _ := newNamespacedClient(t, adapter, "catalog", "1")
+	_, searchHandler, _ := newNamespacedClient(t, adapter, "search", "1")
+	get(catalogHandler, "http://x/a")
+	get(catalogHandler, "http://x/b")
+	get(searchHandler, "http://x/a")
+
+	w := httptest.NewRecorder()
+	catalog.AdminHandler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/flush", nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("flush status = %d, want 204", w.Code)
+	}
+	if keys := adapter.Keys(); len(keys) != 1 {
+		t.Fatalf("adapter holds %d entries after the flush, want the search entry", len(keys))
+	}
+	if got := get(searchHandler, "http://x/a"); got != "search@1" {
+		t.Errorf("search body = %q", got)
+	}
+}
+
+func TestClientWithNamespaceRejectsInvalidValues(t *testing.T) {
+	for _, opt := range []ClientOption{
+		ClientWithNamespace("", "1"),
+		ClientWithNamespace("a\x00b", "1"),
+	} {
+		if _, err := NewClient(ClientWithAdapter(&adapterMock{}), ClientWithTTL(time.Minute), opt); err == nil {
+			t.Error("NewClient() error = nil, want error")
+		}
+	}
+}
@@ -0,0 +1,158 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cache
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// DropPolicy decides what happens to an event when the
+// ClientWithAsyncObserver queue is full.
+type DropPolicy int
+
+const (
+	// DropNewest discards the event being emitted.
+	DropNewest DropPolicy = iota
+
+	// DropOldest discards the oldest queued event to make room.
+	DropOldest
+
+	// Block waits for room in the queue, so a slow observer slows the
+	// requests down again but no event is lost.
+	Block
+)
+
+// asyncObserver delivers events to an observer from a pool of workers.
+type asyncObserver struct {
+	observer Observer
+	policy   DropPolicy
+	queue    chan CacheEvent
+	wg       sync.WaitGroup
+	dropped  atomic.Uint64
+
+	// mu guards closed: senders hold it for reading so Close cannot
+	// close the queue under them.
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newAsyncObserver(observer Observer, size, workers int, policy DropPolicy) *asyncObserver {
+	a := &asyncObserver{
+		observer: observer,
+		policy:   policy,
+		queue:    make(chan CacheEvent, size),
+	}
+	a.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer a.wg.Done()
+			for event := range a.queue {
+				a.observer(event)
+			}
+		}()
+	}
+	return a
+}
+
+func (a *asyncObserver) send(event CacheEvent) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.closed {
+		a.dropped.Add(1)
+		return
+	}
+	switch a.policy {
+	case Block:
+		a.queue <- event
+		return
+	case DropOldest:
+		for {
+			select {
+			case a.queue <- event:
+				return
+			default:
+			}
+			select {
+			case <-a.queue:
+				a.dropped.Add(1)
+			default:
+			}
+		}
+	default:
+		select {
+		case a.queue <- event:
+		default:
+			a.dropped.Add(1)
+		}
+	}
+}
+
+func (a *asyncObserver) close() {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.queue)
+	}
+	a.mu.Unlock()
+	a.wg.Wait()
+}
+
+// ClientWithAsyncObserver delivers the events of ClientWithObserver from
+// workers goroutines through a queue holding up to size events, instead
+// of calling the observer on the request goroutine. policy decides what
+// happens when the queue is full; dropped events are counted in
+// Stats.DroppedEvents. Call Client.Close on shutdown to deliver the
+// queued events. The observer then runs after the request may have
+// completed: it must not read the request body. Optional setting.
+func ClientWithAsyncObserver(size, workers int, policy DropPolicy) ClientOption {
+	return func(c *Client) error {
+		if size < 1 {
+			return errors.New("cache client async observer queue size must be greater than 0")
+		}
+		if workers < 1 {
+			return errors.New("cache client async observer workers must be greater than 0")
+		}
+		if policy < DropNewest || policy > Block {
+			return errors.New("cache client async observer drop policy is invalid")
+		}
+		c.asyncQueueSize = size
+		c.asyncWorkers = workers
+		c.asyncPolicy = policy
+		return nil
+	}
+}
+
+// Close delivers the events queued by ClientWithAsyncObserver and stops
+// its workers; events emitted afterwards are dropped. It is safe to call
+// more than once and does nothing for clients without an async observer.
+func (c *Client) Close() error {
+	if c.async != nil {
+		c.async.close()
+	}
+	return nil
+}
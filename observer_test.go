@@ -0,0 +1,132 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingObserver records events once release is closed.
+type blockingObserver struct {
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+
+	mu   sync.Mutex
+	keys []uint64
+}
+
+func newBlockingObserver() *blockingObserver {
+	return &blockingObserver{started: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (o *blockingObserver) observe(event CacheEvent) {
+	o.once.Do(func() { close(o.started) })
+	<-o.release
+	o.mu.Lock()
+	o.keys = append(o.keys, event.Key)
+	o.mu.Unlock()
+}
+
+func newAsyncTestClient(t *testing.T, observer Observer, size int, policy DropPolicy) *Client {
+	t.Helper()
+	client, err := NewClient(
+		ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+		ClientWithTTL(1*time.Minute),
+		ClientWithObserver(observer),
+		ClientWithAsyncObserver(size, 1, policy),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// A blocked observer must not hold requests up, and Close must deliver
+// every queued event.
+func TestClientWithAsyncObserverDoesNotBlockRequests(t *testing.T) {
+	observer := newBlockingObserver()
+	client := newAsyncTestClient(t, observer.observe, 100, Block)
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://x/async", nil))
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("requests blocked on the observer")
+	}
+
+	close(observer.release)
+	client.Close()
+	// miss, store, hit, hit
+	if n := len(observer.keys); n != 4 {
+		t.Errorf("observer received %d events after Close, want 4", n)
+	}
+
+	client.emit(CacheEvent{Type: CacheEventHit})
+	if err := client.Close(); err != nil {
+		t.Errorf("second Close() error = %v", err)
+	}
+	if n := client.Stats().DroppedEvents; n != 1 {
+		t.Errorf("DroppedEvents = %d after emitting on a closed client, want 1", n)
+	}
+}
+
+func TestClientWithAsyncObserverDropPolicies(t *testing.T) {
+	tests := []struct {
+		policy      DropPolicy
+		wantKeys    []uint64
+		wantDropped uint64
+	}{
+		{DropNewest, []uint64{1, 2, 3}, 2},
+		{DropOldest, []uint64{1, 4, 5}, 2},
+	}
+	for _, tt := range tests {
+		observer := newBlockingObserver()
+		client := newAsyncTestClient(t, observer.observe, 2, tt.policy)
+
+		client.emit(CacheEvent{Key: 1})
+		<-observer.started // the worker holds event 1, the queue is empty
+		for key := uint64(2); key <= 5; key++ {
+			client.emit(CacheEvent{Key: key})
+		}
+		close(observer.release)
+		client.Close()
+
+		if len(observer.keys) != len(tt.wantKeys) {
+			t.Fatalf("policy %d delivered %v, want %v", tt.policy, observer.keys, tt.wantKeys)
+		}
+		for i := range tt.wantKeys {
+			if observer.keys[i] != tt.wantKeys[i] {
+				t.Fatalf("policy %d delivered %v, want %v", tt.policy, observer.keys, tt.wantKeys)
+			}
+		}
+		if got := client.Stats().DroppedEvents; got != tt.wantDropped {
+			t.Errorf("policy %d DroppedEvents = %d, want %d", tt.policy, got, tt.wantDropped)
+		}
+	}
+}
+
+func TestClientWithAsyncObserverRejectsInvalidSettings(t *testing.T) {
+	for _, opts := range [][]ClientOption{
+		{ClientWithAsyncObserver(0, 1, DropNewest)},
+		{ClientWithAsyncObserver(1, 0, DropNewest)},
+		{ClientWithAsyncObserver(1, 1, DropPolicy(9))},
+		{ClientWithAsyncObserver(1, 1, DropNewest)}, // no observer
+	} {
+		opts = append(opts, ClientWithAdapter(&adapterMock{}), ClientWithTTL(time.Minute))
+		if _, err := NewClient(opts...); err == nil {
+			t.Error("NewClient() error = nil, want error")
+		}
+	}
+}
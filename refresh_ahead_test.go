@@ -0,0 +1,221 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// A hit inside the refresh-ahead window must be served from cache and
+// trigger a single background refill.
+func TestClientWithRefreshAheadRefreshesBeforeExpiry(t *testing.T) {
+	const url = "http://x/ahead"
+	now := time.Now()
+	adapter := &adapterMock{
+		store: map[uint64][]byte{
+			generateKey(url): Response{
+				Value:      []byte("old"),
+				StoredAt:   now.Add(-55 * time.Second),
+				Expiration: now.Add(5 * time.Second),
+			}.Bytes(),
+		},
+	}
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithRefreshAhead(0.2),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var calls int64
+	refreshed := make(chan struct{}, 1)
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		fmt.Fprint(w, "new")
+		select {
+		case refreshed <- struct{}{}:
+		default:
+		}
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+	if got := w.Body.String(); got != "old" {
+		t.Fatalf("body = %q, want old", got)
+	}
+
+	select {
+	case <-refreshed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("refresh-ahead never ran")
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	stored, ok := adapter.Get(generateKey(url))
+	if !ok {
+		t.Fatal("cache entry missing after refresh-ahead")
+	}
+	resp := BytesToResponse(stored)
+	if string(resp.Value) != "new" {
+		t.Fatalf("cache value after refresh-ahead = %q, want new", string(resp.Value))
+	}
+	if got := atomic.LoadInt64(&calls); got != 1 {
+		t.Fatalf("origin called %d times, want 1", got)
+	}
+}
+
+// A hit outside the refresh-ahead window must not reach the origin.
+func TestClientWithRefreshAheadSkipsFreshEntries(t *testing.T) {
+	const url = "http://x/ahead-fresh"
+	now := time.Now()
+	adapter := &adapterMock{
+		store: map[uint64][]byte{
+			generateKey(url): Response{
+				Value:          []byte("cached"),
+				StoredAt:       now,
+				Expiration:     now.Add(1 * time.Minute),
+				OriginDuration: time.Millisecond,
+			}.Bytes(),
+		},
+	}
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithRefreshAhead(0.2),
+		ClientWithProbabilisticRefreshAhead(1),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var calls int64
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		fmt.Fprint(w, "new")
+	}))
+
+	for i := 0; i < 20; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, url, nil))
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	if got := atomic.LoadInt64(&calls); got != 0 {
+		t.Fatalf("origin called %d times for a fresh entry, want 0", got)
+	}
+}
+
+// refreshTracer counts the background refreshes started.
+type refreshTracer struct {
+	refreshes atomic.Int32
+}
+
+type nopSpan struct{}
+
+func (nopSpan) End(SpanAttributes) {}
+
+func (t *refreshTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+func (t *refreshTracer) StartLinked(ctx context.Context, name string, link context.Context) (context.Context, Span) {
+	if name == SpanRefresh {
+		t.refreshes.Add(1)
+	}
+	return ctx, nopSpan{}
+}
+
+// Hits on an entry whose refresh is in flight do not start another one,
+// nor a goroutine waiting for it.
+func TestRefreshAheadStartsOneRefreshPerEntry(t *testing.T) {
+	const url = "http://x/hot"
+	now := time.Now()
+	adapter := &adapterMock{
+		store: map[uint64][]byte{
+			generateKey(url): Response{
+				Value:      []byte("old"),
+				StoredAt:   now.Add(-55 * time.Second),
+				Expiration: now.Add(5 * time.Second),
+			}.Bytes(),
+		},
+	}
+	tracer := &refreshTracer{}
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(time.Minute),
+		ClientWithRefreshAhead(0.2),
+		ClientWithTracer(tracer),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	done := make(chan struct{})
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		fmt.Fprint(w, "new")
+		close(done)
+	}))
+
+	for i := 0; i < 50; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		if got := w.Body.String(); got != "old" {
+			t.Fatalf("body = %q, want old", got)
+		}
+	}
+	if got := tracer.refreshes.Load(); got != 1 {
+		t.Errorf("%d refreshes started for 50 hits, want 1", got)
+	}
+	close(release)
+	<-done
+}
+
+func TestRefreshAheadProbabilistic(t *testing.T) {
+	client := &Client{refreshAheadBeta: 1}
+	now := time.Now()
+
+	expired := Response{Expiration: now, OriginDuration: time.Second}
+	if !client.refreshAhead(expired, now) {
+		t.Error("XFetch must always refresh once remaining TTL reaches 0")
+	}
+
+	far := Response{Expiration: now.Add(24 * time.Hour), OriginDuration: time.Millisecond}
+	for i := 0; i < 1000; i++ {
+		if client.refreshAhead(far, now) {
+			t.Fatal("XFetch refreshed an entry a day away from expiry with a 1ms origin")
+		}
+	}
+
+	unmeasured := Response{StoredAt: now.Add(-55 * time.Second), Expiration: now.Add(5 * time.Second)}
+	if !client.refreshAhead(unmeasured, now) {
+		t.Error("XFetch must fall back to the fixed fraction for entries without an origin duration")
+	}
+	unmeasured.StoredAt = now
+	unmeasured.Expiration = now.Add(time.Minute)
+	if client.refreshAhead(unmeasured, now) {
+		t.Error("XFetch refreshed an unmeasured entry outside the fallback fraction")
+	}
+
+	noExpiry := Response{OriginDuration: time.Second}
+	if client.refreshAhead(noExpiry, now) {
+		t.Error("entries without expiration must never be refreshed ahead")
+	}
+}
+
+func TestClientWithRefreshAheadRejectsInvalidValues(t *testing.T) {
+	for _, opt := range []ClientOption{
+		ClientWithRefreshAhead(-0.1),
+		ClientWithRefreshAhead(1),
+		ClientWithProbabilisticRefreshAhead(-1),
+	} {
+		if _, err := NewClient(ClientWithAdapter(&adapterMock{}), ClientWithTTL(time.Minute), opt); err == nil {
+			t.Error("NewClient() error = nil, want error")
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package cache
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -160,3 +162,139 @@ func TestClientWithoutSingleflightDoesNotCoalesce(t *testing.T) {
 		t.Errorf("origin handler called %d times without singleflight, want %d", got, N)
 	}
 }
+
+// A panicking leader must not wedge the key: followers get 502, the
+// leader's panic is re-raised on its own request, and the next request
+// for the key runs the origin again.
+func TestClientWithSingleflightRecoversLeaderPanic(t *testing.T) {
+	adapter := &slowAdapter{store: map[uint64][]byte{}}
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithSingleflight(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var calls int64
+	release := make(chan struct{})
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&calls, 1) == 1 {
+			<-release
+			panic("origin exploded")
+		}
+		fmt.Fprint(w, "recovered")
+	}))
+
+	leaderPanic := make(chan interface{}, 1)
+	go func() {
+		defer func() { leaderPanic <- recover() }()
+		handler.ServeHTTP(httptest.NewRecorder(),
+			httptest.NewRequest(http.MethodGet, "http://x/sf-panic", nil))
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	const N = 10
+	codes := make([]int, N)
+	var wg sync.WaitGroup
+	wg.Add(N)
+	for i := 0; i < N; i++ {
+		i := i
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://x/sf-panic", nil))
+			codes[i] = w.Code
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("followers still blocked after the leader panicked")
+	}
+
+	if v := <-leaderPanic; v != "origin exploded" {
+		t.Fatalf("leader recovered %v, want the original panic value", v)
+	}
+	for i, code := range codes {
+		if code != http.StatusBadGateway {
+			t.Errorf("follower %d status = %d, want 502", i, code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://x/sf-panic", nil))
+	if got := w.Body.String(); got != "recovered" {
+		t.Fatalf("body after panic = %q, want recovered", got)
+	}
+}
+
+// Followers that exceed ClientWithSingleflightTimeout must stop waiting
+// and call the origin themselves.
+func TestClientWithSingleflightTimeoutFallsBackToOrigin(t *testing.T) {
+	adapter := &slowAdapter{store: map[uint64][]byte{}}
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithSingleflight(),
+		ClientWithSingleflightTimeout(20*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var calls int64
+	release := make(chan struct{})
+	defer close(release)
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&calls, 1) == 1 {
+			<-release
+		}
+		fmt.Fprint(w, "direct")
+	}))
+
+	go handler.ServeHTTP(httptest.NewRecorder(),
+		httptest.NewRequest(http.MethodGet, "http://x/sf-timeout", nil))
+	time.Sleep(10 * time.Millisecond)
+
+	w := httptest.NewRecorder()
+	start := time.Now()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://x/sf-timeout", nil))
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("follower waited %v, want it to give up after the timeout", elapsed)
+	}
+	if got := w.Body.String(); got != "direct" {
+		t.Fatalf("follower body = %q, want direct", got)
+	}
+	if got := atomic.LoadInt64(&calls); got != 2 {
+		t.Fatalf("origin called %d times, want 2 (leader + timed out follower)", got)
+	}
+}
+
+// A follower whose request context is canceled must stop waiting.
+func TestSingleflightFollowerRespectsContext(t *testing.T) {
+	var g singleflightGroup
+	release := make(chan struct{})
+	defer close(release)
+	go g.Do(context.Background(), "k", 0, func() interface{} {
+		<-release
+		return nil
+	})
+	time.Sleep(10 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, shared, err := g.Do(ctx, "k", 0, func() interface{} { return nil })
+	if !shared || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Do() = shared %v, err %v; want shared follower with context.DeadlineExceeded", shared, err)
+	}
+}
@@ -0,0 +1,157 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// A soft Drop must keep the entry in the adapter with an expiration in
+// the past instead of releasing it.
+func TestClientWithSoftPurgeDropKeepsEntryAsStale(t *testing.T) {
+	const url = "http://x/soft-drop"
+	adapter := &adapterMock{
+		store: map[uint64][]byte{
+			generateKey(url): Response{
+				Value:      []byte("cached"),
+				Expiration: time.Now().Add(1 * time.Minute),
+			}.Bytes(),
+		},
+	}
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithStaleWhileRevalidate(1*time.Minute),
+		ClientWithSoftPurge(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Drop(httptest.NewRequest(http.MethodGet, url, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	b, ok := adapter.Get(generateKey(url))
+	if !ok {
+		t.Fatal("soft purge released the entry")
+	}
+	resp := BytesToResponse(b)
+	if resp.Valid() {
+		t.Fatalf("soft-purged entry still valid, expiration = %v", resp.Expiration)
+	}
+	if string(resp.Value) != "cached" {
+		t.Fatalf("soft-purged value = %q, want cached", string(resp.Value))
+	}
+}
+
+// With a stale window, a soft PURGE must keep serving the old copy while
+// exactly one background request refreshes it.
+func TestClientWithSoftPurgeServesStaleWhileRefreshing(t *testing.T) {
+	const url = "http://x/soft-purge"
+	adapter := &adapterMock{
+		store: map[uint64][]byte{
+			generateKey(url): Response{
+				Value:      []byte("old"),
+				Expiration: time.Now().Add(1 * time.Minute),
+			}.Bytes(),
+		},
+	}
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithPurge(),
+		ClientWithSoftPurge(),
+		ClientWithStaleWhileRevalidate(1*time.Second),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var calls int64
+	release := make(chan struct{})
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		<-release
+		fmt.Fprint(w, "new")
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(methodPurge, url, nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("purge status = %d, want 204", w.Code)
+	}
+
+	for i := 0; i < 10; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		if got := w.Body.String(); got != "old" {
+			t.Fatalf("request %d body = %q, want old", i, got)
+		}
+	}
+
+	close(release)
+	time.Sleep(50 * time.Millisecond)
+
+	if got := atomic.LoadInt64(&calls); got != 1 {
+		t.Fatalf("origin called %d times after soft purge, want 1", got)
+	}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+	if got := w.Body.String(); got != "new" {
+		t.Fatalf("body after refresh = %q, want new", got)
+	}
+}
+
+// Soft purging a key that is not cached must not create an entry.
+func TestClientWithSoftPurgeMissingEntryIsNoop(t *testing.T) {
+	adapter := &adapterMock{store: map[uint64][]byte{}}
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithSoftPurge(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Drop(httptest.NewRequest(http.MethodGet, "http://x/none", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if len(adapter.store) != 0 {
+		t.Fatalf("adapter has %d entries after soft purge of a missing key, want 0", len(adapter.store))
+	}
+}
+
+// Without a stale window a soft-purged entry could never be served
+// again, so it must be released rather than rewritten with an expiration
+// in the past, which TTL-aware backends may turn into "never expires".
+func TestClientWithSoftPurgeWithoutStaleWindowReleases(t *testing.T) {
+	const url = "http://x/soft-drop"
+	adapter := &adapterMock{
+		store: map[uint64][]byte{
+			generateKey(url): Response{
+				Value:      []byte("cached"),
+				Expiration: time.Now().Add(1 * time.Minute),
+			}.Bytes(),
+		},
+	}
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithSoftPurge(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Drop(httptest.NewRequest(http.MethodGet, url, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := adapter.Get(generateKey(url)); ok {
+		t.Fatal("soft purge without a stale window kept the entry")
+	}
+}
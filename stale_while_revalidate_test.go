@@ -1,9 +1,11 @@
 package cache
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -160,3 +162,52 @@ func TestClientWithStaleWhileRevalidateCoalescesRefresh(t *testing.T) {
 		t.Errorf("background origin called %d times, want exactly 1", got)
 	}
 }
+
+// A panic in the background refresh has no request to surface on: it
+// must be reported as an error event carrying the panic and its stack.
+func TestClientWithStaleWhileRevalidateReportsRefreshPanic(t *testing.T) {
+	const url = "http://x/swr-panic"
+	adapter := &adapterMock{
+		store: map[uint64][]byte{
+			generateKey(url): Response{
+				Value:      []byte("stale"),
+				Expiration: time.Now().Add(-10 * time.Millisecond),
+			}.Bytes(),
+		},
+	}
+	reported := make(chan CacheEvent, 1)
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithStaleWhileRevalidate(1*time.Second),
+		ClientWithObserver(func(event CacheEvent) {
+			if event.Type == CacheEventError {
+				reported <- event
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("refresh exploded")
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, url, nil))
+
+	select {
+	case event := <-reported:
+		if event.Reason != ReasonPanic || event.Key != generateKey(url) {
+			t.Errorf("error event = %+v, want a panic event for the refreshed key", event)
+		}
+		var pe *panicError
+		if !errors.As(event.Err, &pe) || pe.value != "refresh exploded" {
+			t.Fatalf("error event Err = %v, want the recovered panic", event.Err)
+		}
+		if !strings.Contains(event.Err.Error(), "goroutine") {
+			t.Errorf("panic error lacks the stack: %v", event.Err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the refresh panic was not reported")
+	}
+}
@@ -0,0 +1,258 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cache
+
+import (
+	"errors"
+	"expvar"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// statsEventTypes lists the event types counted by Client.Stats.
+var statsEventTypes = [...]CacheEventType{
+	CacheEventHit,
+	CacheEventMiss,
+	CacheEventStale,
+	CacheEventRefresh,
+	CacheEventStore,
+	CacheEventPurge,
+	CacheEventBypass,
+	CacheEventCollision,
+	CacheEventCorrupt,
+	CacheEventSkip,
+	CacheEventError,
+	CacheEventEviction,
+	CacheEventCircuit,
+}
+
+// statsBypassReasons lists the reasons a request can skip the cache.
+var statsBypassReasons = [...]string{
+	ReasonMethod,
+	ReasonPath,
+	ReasonRequestNoStore,
+	ReasonKeyError,
+	ReasonUnavailable,
+}
+
+// Stats is a snapshot of the counters a Client keeps since it was
+// created.
+type Stats struct {
+	// Events counts middleware events by type.
+	Events map[CacheEventType]uint64 `json:"events"`
+
+	// Bypasses counts requests served without consulting the cache, by
+	// reason: "method", "path", "request-no-store" or "key-error".
+	Bypasses map[string]uint64 `json:"bypasses"`
+
+	// BytesServed is the total size of the response bodies served from
+	// cache, stale ones included.
+	BytesServed uint64 `json:"bytesServed"`
+
+	// BytesStored is the total size of the encoded entries written to
+	// the adapter.
+	BytesStored uint64 `json:"bytesStored"`
+
+	// OriginRequests is the number of requests that reached the origin
+	// handler through the cacheable path, background refreshes included.
+	OriginRequests uint64 `json:"originRequests"`
+
+	// OriginLatency is the total time spent in the origin handler by
+	// those requests.
+	OriginLatency time.Duration `json:"originLatency"`
+
+	// SingleflightWaiters is the number of requests currently waiting
+	// for a coalesced origin request.
+	SingleflightWaiters int64 `json:"singleflightWaiters"`
+
+	// DroppedEvents counts the events ClientWithAsyncObserver could not
+	// deliver.
+	DroppedEvents uint64 `json:"droppedEvents"`
+
+	// Adapter reports the adapter usage, if the adapter implements
+	// AdapterStats.
+	Adapter *AdapterUsage `json:"adapter,omitempty"`
+}
+
+// AdapterUsage describes what an adapter holds.
+type AdapterUsage struct {
+	// Entries is the number of cached entries.
+	Entries int `json:"entries"`
+
+	// Bytes is the total size of the cached entries.
+	Bytes int64 `json:"bytes"`
+
+	// Evictions counts the entries released to make room for others.
+	Evictions uint64 `json:"evictions"`
+}
+
+// AdapterStats is an optional Adapter extension for adapters that can
+// report their usage, included in Client.Stats.
+type AdapterStats interface {
+	Usage() AdapterUsage
+}
+
+// HitRatio returns the fraction of lookups that were served from cache,
+// or 0 when there was none.
+func (s Stats) HitRatio() float64 {
+	hits := s.Events[CacheEventHit]
+	total := hits + s.Events[CacheEventMiss] + s.Events[CacheEventStale] +
+		s.Events[CacheEventCollision] + s.Events[CacheEventCorrupt]
+	if total == 0 {
+		return 0
+	}
+	return float64(hits) / float64(total)
+}
+
+// MeanOriginLatency returns the average time spent in the origin
+// handler, or 0 when it was never called.
+func (s Stats) MeanOriginLatency() time.Duration {
+	if s.OriginRequests == 0 {
+		return 0
+	}
+	return s.OriginLatency / time.Duration(s.OriginRequests)
+}
+
+// clientStats holds the Client counters. Every field is updated
+// atomically so recording never takes a lock.
+type clientStats struct {
+	events         [len(statsEventTypes)]atomic.Uint64
+	bypasses       [len(statsBypassReasons)]atomic.Uint64
+	bytesServed    atomic.Uint64
+	bytesStored    atomic.Uint64
+	originRequests atomic.Uint64
+	originNanos    atomic.Int64
+}
+
+func (s *clientStats) event(eventType CacheEventType) {
+	for i, t := range statsEventTypes {
+		if t == eventType {
+			s.events[i].Add(1)
+			return
+		}
+	}
+}
+
+func (s *clientStats) bypass(reason string) {
+	for i, r := range statsBypassReasons {
+		if r == reason {
+			s.bypasses[i].Add(1)
+			return
+		}
+	}
+}
+
+func (s *clientStats) served(n int) {
+	s.bytesServed.Add(uint64(n))
+}
+
+func (s *clientStats) stored(n int) {
+	s.bytesStored.Add(uint64(n))
+}
+
+func (s *clientStats) origin(took time.Duration) {
+	s.originRequests.Add(1)
+	s.originNanos.Add(int64(took))
+}
+
+// Stats returns a snapshot of the client counters. Counters are read one
+// by one, so a snapshot taken under load may be off by the requests in
+// flight.
+func (c *Client) Stats() Stats {
+	s := Stats{
+		Events:         make(map[CacheEventType]uint64, len(statsEventTypes)),
+		Bypasses:       make(map[string]uint64, len(statsBypassReasons)),
+		BytesServed:    c.stats.bytesServed.Load(),
+		BytesStored:    c.stats.bytesStored.Load(),
+		OriginRequests: c.stats.originRequests.Load(),
+		OriginLatency:  time.Duration(c.stats.originNanos.Load()),
+
+		SingleflightWaiters: c.sf.waiters.Load(),
+	}
+	if c.async != nil {
+		s.DroppedEvents = c.async.dropped.Load()
+	}
+	for i, t := range statsEventTypes {
+		s.Events[t] = c.stats.events[i].Load()
+	}
+	for i, r := range statsBypassReasons {
+		s.Bypasses[r] = c.stats.bypasses[i].Load()
+	}
+	if a, ok := adapterAs[AdapterStats](c.adapter); ok {
+		usage := a.Usage()
+		s.Adapter = &usage
+	}
+	return s
+}
+
+// ClientWithExpvar publishes the client Stats as an expvar variable with
+// the given name, served by the expvar handler at /debug/vars. expvar
+// names are global, so NewClient returns an error if name is already
+// taken. The variable is only published once every other option was
+// applied successfully.
+func ClientWithExpvar(name string) ClientOption {
+	return func(c *Client) error {
+		if name == "" {
+			return errors.New("cache client expvar name is not set")
+		}
+		c.expvarName = name
+		return nil
+	}
+}
+
+// expvarMu serializes the check and the publication of expvar names,
+// since expvar.Publish panics on a duplicate.
+var expvarMu sync.Mutex
+
+// publishExpvar publishes the client Stats under c.expvarName.
+func (c *Client) publishExpvar() error {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+
+	if expvar.Get(c.expvarName) != nil {
+		return errors.New("cache client expvar name " + c.expvarName + " is already published")
+	}
+	expvar.Publish(c.expvarName, expvar.Func(func() interface{} {
+		return c.Stats()
+	}))
+	return nil
+}
+
+// bypass records a request that skips the cache for the given reason,
+// as an error event when err is not nil.
+func (c *Client) bypass(w http.ResponseWriter, r *http.Request, debug bool, reason string, err error) {
+	c.stats.bypass(reason)
+	event := CacheEvent{Type: CacheEventBypass, Request: r, Reason: reason}
+	if err != nil {
+		event.Type = CacheEventError
+		event.Err = err
+	}
+	c.emit(event)
+	if debug {
+		setDebugBypass(w.Header(), reason)
+	}
+}
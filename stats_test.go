@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientStats(t *testing.T) {
+	client, err := NewClient(
+		ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+		ClientWithTTL(1*time.Minute),
+		ClientWithRespectCacheControl(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(2 * time.Millisecond)
+		w.Write([]byte("hello"))
+	}))
+
+	for i := 0; i < 3; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://x/stats", nil))
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "http://x/stats", nil))
+	noStore := httptest.NewRequest(http.MethodGet, "http://x/stats", nil)
+	noStore.Header.Set("Cache-Control", "no-store")
+	handler.ServeHTTP(httptest.NewRecorder(), noStore)
+
+	stats := client.Stats()
+	if stats.Events[CacheEventMiss] != 1 || stats.Events[CacheEventStore] != 1 || stats.Events[CacheEventHit] != 2 {
+		t.Errorf("Events = %v, want 1 miss, 1 store and 2 hits", stats.Events)
+	}
+	if stats.Bypasses[ReasonMethod] != 1 || stats.Bypasses[ReasonRequestNoStore] != 1 {
+		t.Errorf("Bypasses = %v, want 1 method and 1 request-no-store", stats.Bypasses)
+	}
+	if stats.BytesServed != 10 {
+		t.Errorf("BytesServed = %d, want 10", stats.BytesServed)
+	}
+	if stats.BytesStored == 0 {
+		t.Error("BytesStored = 0, want the stored entry size")
+	}
+	if stats.OriginRequests != 1 || stats.MeanOriginLatency() < 2*time.Millisecond {
+		t.Errorf("origin requests = %d, mean latency = %v; want 1 and at least 2ms", stats.OriginRequests, stats.MeanOriginLatency())
+	}
+	if got := stats.HitRatio(); got < 0.66 || got > 0.67 {
+		t.Errorf("HitRatio() = %v, want 2/3", got)
+	}
+}
+
+func TestClientWithExpvar(t *testing.T) {
+	client, err := NewClient(
+		ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+		ClientWithTTL(1*time.Minute),
+		ClientWithExpvar("http_cache_test_stats"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Middleware(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://x/expvar", nil))
+
+	v := expvar.Get("http_cache_test_stats")
+	if v == nil {
+		t.Fatal("stats were not published")
+	}
+	if !strings.Contains(v.String(), `"miss":1`) {
+		t.Errorf("published stats = %s, want 1 miss", v.String())
+	}
+
+	_, err = NewClient(
+		ClientWithAdapter(&adapterMock{}),
+		ClientWithTTL(1*time.Minute),
+		ClientWithExpvar("http_cache_test_stats"),
+	)
+	if err == nil {
+		t.Error("NewClient() with a taken expvar name error = nil, want error")
+	}
+}
+
+// A client that fails to build must not take its expvar name.
+func TestClientWithExpvarPublishesOnlyValidClients(t *testing.T) {
+	const name = "http_cache_test_invalid_stats"
+	if _, err := NewClient(
+		ClientWithAdapter(&adapterMock{}),
+		ClientWithExpvar(name),
+	); err == nil {
+		t.Fatal("NewClient() without a ttl error = nil, want error")
+	}
+	if expvar.Get(name) != nil {
+		t.Fatal("a client that failed to build published its stats")
+	}
+
+	if _, err := NewClient(
+		ClientWithAdapter(&adapterMock{}),
+		ClientWithTTL(1*time.Minute),
+		ClientWithExpvar(name),
+	); err != nil {
+		t.Fatalf("NewClient() with a free expvar name error = %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Responses must expire after the TTL configured for their status code
+// or class, and configured error statuses must be negatively cached even
+// though the default filter rejects them.
+func TestClientWithStatusCodeTTLs(t *testing.T) {
+	adapter := &adapterMock{store: map[uint64][]byte{}}
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Hour),
+		ClientWithStatusCodeTTLs(map[string]time.Duration{
+			"301": 24 * time.Hour,
+			"4xx": 30 * time.Second,
+			"410": 10 * time.Second,
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path    string
+		status  int
+		wantTTL time.Duration
+		cached  bool
+	}{
+		{"/ok", http.StatusOK, 1 * time.Hour, true},
+		{"/moved", http.StatusMovedPermanently, 24 * time.Hour, true},
+		{"/missing", http.StatusNotFound, 30 * time.Second, true},
+		{"/gone", http.StatusGone, 10 * time.Second, true},
+		{"/broken", http.StatusInternalServerError, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte("body"))
+			}))
+			before := time.Now()
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://x"+tt.path, nil))
+
+			b, ok := adapter.Get(generateKey("http://x" + tt.path))
+			if ok != tt.cached {
+				t.Fatalf("cached = %v, want %v", ok, tt.cached)
+			}
+			if !ok {
+				return
+			}
+			ttl := BytesToResponse(b).Expiration.Sub(before)
+			if ttl < tt.wantTTL || ttl > tt.wantTTL+time.Second {
+				t.Errorf("ttl = %v, want %v", ttl, tt.wantTTL)
+			}
+		})
+	}
+}
+
+func TestClientWithStatusCodeTTLsRejectsInvalidKeys(t *testing.T) {
+	for _, ttls := range []map[string]time.Duration{
+		{"abc": time.Second},
+		{"99": time.Second},
+		{"6xx": time.Second},
+		{"200": -time.Second},
+	} {
+		_, err := NewClient(
+			ClientWithAdapter(&adapterMock{}),
+			ClientWithTTL(time.Minute),
+			ClientWithStatusCodeTTLs(ttls),
+		)
+		if err == nil {
+			t.Errorf("NewClient() with %v error = nil, want error", ttls)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// An entry written through Store must be served by the middleware
+// without reaching the origin.
+func TestStoreWritesEntryServedByMiddleware(t *testing.T) {
+	adapter := &adapterMock{store: map[uint64][]byte{}}
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(1*time.Minute),
+		ClientWithVaryHeaders([]string{"Accept-Language"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://x/store?b=2&a=1", nil)
+	req.Header.Set("Accept-Language", "pt-BR")
+	header := http.Header{}
+	header.Set("Content-Type", "text/plain")
+	if err := client.Store(context.Background(), req, http.StatusCreated, header, []byte("warm"), 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := client.Lookup(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.TTL <= 9*time.Second || entry.TTL > 10*time.Second {
+		t.Errorf("TTL = %v, want 10s", entry.TTL)
+	}
+
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("origin called for a stored entry")
+	}))
+	w := httptest.NewRecorder()
+	hit := httptest.NewRequest(http.MethodGet, "http://x/store?a=1&b=2", nil)
+	hit.Header.Set("Accept-Language", "pt-BR")
+	handler.ServeHTTP(w, hit)
+	if w.Code != http.StatusCreated || w.Body.String() != "warm" || w.Header().Get("Content-Type") != "text/plain" {
+		t.Fatalf("response = %d %q %v, want the stored entry", w.Code, w.Body.String(), w.Header())
+	}
+}
+
+func TestStoreUsesClientTTLByDefault(t *testing.T) {
+	client, err := NewClient(
+		ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+		ClientWithTTL(1*time.Minute),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://x/store-default", nil)
+	if err := client.Store(context.Background(), req, http.StatusOK, nil, []byte("v"), 0); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := client.Lookup(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.TTL <= 59*time.Second || entry.TTL > time.Minute {
+		t.Errorf("TTL = %v, want the client TTL", entry.TTL)
+	}
+
+	if err := client.Store(context.Background(), req, 42, nil, nil, 0); err == nil {
+		t.Error("Store() with an invalid status error = nil, want error")
+	}
+}
+
+// Warm must run every request through the middleware once, with bounded
+// concurrency, so subsequent requests hit the cache.
+func TestWarmPopulatesCache(t *testing.T) {
+	client, err := NewClient(
+		ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+		ClientWithTTL(1*time.Minute),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var calls, inflight, peak int64
+	origin := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		n := atomic.AddInt64(&inflight, 1)
+		for {
+			p := atomic.LoadInt64(&peak)
+			if n <= p || atomic.CompareAndSwapInt64(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt64(&inflight, -1)
+		fmt.Fprint(w, r.URL.Path)
+	})
+
+	var requests []*http.Request
+	for i := 0; i < 20; i++ {
+		requests = append(requests, httptest.NewRequest(http.MethodGet, fmt.Sprintf("http://x/warm/%d", i), nil))
+	}
+	if err := client.Warm(context.Background(), origin, requests, 4); err != nil {
+		t.Fatal(err)
+	}
+	if got := atomic.LoadInt64(&calls); got != 20 {
+		t.Fatalf("origin called %d times while warming, want 20", got)
+	}
+	if got := atomic.LoadInt64(&peak); got > 4 {
+		t.Fatalf("peak concurrency = %d, want at most 4", got)
+	}
+
+	for _, r := range requests {
+		if _, err := client.Lookup(context.Background(), r); err != nil {
+			t.Fatalf("Lookup(%s) error = %v after warming", r.URL, err)
+		}
+	}
+}
+
+func TestWarmStopsWhenContextIsDone(t *testing.T) {
+	client, err := NewClient(
+		ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+		ClientWithTTL(1*time.Minute),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var requests []*http.Request
+	for i := 0; i < 5; i++ {
+		requests = append(requests, httptest.NewRequest(http.MethodGet, fmt.Sprintf("http://x/canceled/%d", i), nil))
+	}
+	if err := client.Warm(ctx, http.NotFoundHandler(), requests, 1); err != context.Canceled {
+		t.Fatalf("Warm() error = %v, want context.Canceled", err)
+	}
+}
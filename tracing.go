@@ -0,0 +1,134 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Span names started by ClientWithTracer.
+const (
+	// SpanLookup covers reading and decoding the cached entry.
+	SpanLookup = "http-cache.lookup"
+
+	// SpanOrigin covers the origin handler. Spans the handler starts
+	// are its children.
+	SpanOrigin = "http-cache.origin"
+
+	// SpanStore covers encoding and writing an entry to the adapter.
+	SpanStore = "http-cache.store"
+
+	// SpanSingleflight covers a request coalesced with
+	// ClientWithSingleflight, including the wait for the leader.
+	SpanSingleflight = "http-cache.singleflight"
+
+	// SpanRefresh covers a background refresh. It is a new root linked
+	// to the span of the request that triggered it.
+	SpanRefresh = "http-cache.refresh"
+)
+
+// Tracer starts spans around the middleware operations. The tracing/otel
+// package implements it for OpenTelemetry.
+type Tracer interface {
+	// Start starts a span called name as a child of the span in ctx.
+	Start(ctx context.Context, name string) (context.Context, Span)
+
+	// StartLinked starts a span called name as a new root carrying the
+	// values of ctx and linked to the span in link.
+	StartLinked(ctx context.Context, name string, link context.Context) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	// End records attrs and ends the span.
+	End(attrs SpanAttributes)
+}
+
+// SpanAttributes describes the outcome of a traced operation. Zero
+// fields do not apply to the operation.
+type SpanAttributes struct {
+	// Key is the cache key.
+	Key uint64
+
+	// Event is the event the operation resulted in: hit, miss or stale
+	// for a lookup, store when an entry was written.
+	Event CacheEventType
+
+	// TTL is the time left until the entry expires, negative for stale
+	// entries.
+	TTL time.Duration
+
+	// Size is the response body size.
+	Size int
+
+	// StatusCode is the response status code.
+	StatusCode int
+
+	// Shared reports whether a singleflight or refresh span waited for
+	// an origin request started by another caller.
+	Shared bool
+}
+
+// ClientWithTracer sets a Tracer creating spans for cache lookups, origin
+// fetches, stores, singleflight waits and background refreshes.
+// Optional setting.
+func ClientWithTracer(tracer Tracer) ClientOption {
+	return func(c *Client) error {
+		if tracer == nil {
+			return errors.New("cache client tracer is not set")
+		}
+		c.tracer = tracer
+		return nil
+	}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) End(SpanAttributes) {}
+
+func (c *Client) startSpan(ctx context.Context, name string) (context.Context, Span) {
+	if c.tracer == nil {
+		return ctx, noopSpan{}
+	}
+	return c.tracer.Start(ctx, name)
+}
+
+func (c *Client) startLinkedSpan(ctx context.Context, name string, link context.Context) (context.Context, Span) {
+	if c.tracer == nil {
+		return ctx, noopSpan{}
+	}
+	return c.tracer.StartLinked(ctx, name, link)
+}
+
+// remainingTTL returns the time left until expiration, or 0 for entries
+// without expiration.
+func remainingTTL(expiration time.Time) time.Duration {
+	if expiration.IsZero() {
+		return 0
+	}
+	return time.Until(expiration)
+}
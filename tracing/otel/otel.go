@@ -0,0 +1,142 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package otel implements cache.Tracer with OpenTelemetry, so requests
+// served from cache show up in traces:
+//
+//	tracer, err := otel.New()
+//	client, err := cache.NewClient(
+//		cache.ClientWithAdapter(adapter),
+//		cache.ClientWithTTL(10*time.Minute),
+//		cache.ClientWithTracer(tracer),
+//	)
+//
+// Spans are children of the span in the request context, e.g. the one
+// started by otelhttp, and carry the http_cache.* attributes below.
+package otel
+
+import (
+	"context"
+	"errors"
+
+	cache "github.com/victorspringer/http-cache"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ScopeName is the instrumentation scope of the spans.
+const ScopeName = "github.com/victorspringer/http-cache"
+
+// Span attribute keys.
+const (
+	KeyAttribute        = attribute.Key("http_cache.key")
+	EventAttribute      = attribute.Key("http_cache.event")
+	TTLAttribute        = attribute.Key("http_cache.ttl")
+	SizeAttribute       = attribute.Key("http_cache.size")
+	SharedAttribute     = attribute.Key("http_cache.shared")
+	StatusCodeAttribute = attribute.Key("http.response.status_code")
+)
+
+// Tracer is a cache.Tracer backed by an OpenTelemetry tracer.
+type Tracer struct {
+	provider trace.TracerProvider
+	tracer   trace.Tracer
+}
+
+// Option is used to set Tracer settings.
+type Option func(t *Tracer) error
+
+// New initializes a Tracer. It uses the global TracerProvider unless
+// WithTracerProvider is given.
+func New(opts ...Option) (*Tracer, error) {
+	t := &Tracer{}
+	for _, opt := range opts {
+		if err := opt(t); err != nil {
+			return nil, err
+		}
+	}
+	if t.provider == nil {
+		t.provider = otel.GetTracerProvider()
+	}
+	t.tracer = t.provider.Tracer(ScopeName)
+	return t, nil
+}
+
+// WithTracerProvider sets the TracerProvider creating the spans.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(t *Tracer) error {
+		if provider == nil {
+			return errors.New("otel tracer provider is not set")
+		}
+		t.provider = provider
+		return nil
+	}
+}
+
+// Start implements cache.Tracer.
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, cache.Span) {
+	ctx, s := t.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindInternal))
+	return ctx, span{s}
+}
+
+// StartLinked implements cache.Tracer.
+func (t *Tracer) StartLinked(ctx context.Context, name string, link context.Context) (context.Context, cache.Span) {
+	ctx, s := t.tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithNewRoot(),
+		trace.WithLinks(trace.LinkFromContext(link)),
+	)
+	return ctx, span{s}
+}
+
+type span struct {
+	trace.Span
+}
+
+func (s span) End(attrs cache.SpanAttributes) {
+	if s.IsRecording() {
+		kv := make([]attribute.KeyValue, 0, 6)
+		if attrs.Key != 0 {
+			kv = append(kv, KeyAttribute.String(cache.KeyAsString(attrs.Key)))
+		}
+		if attrs.Event != "" {
+			kv = append(kv, EventAttribute.String(string(attrs.Event)))
+		}
+		if attrs.TTL != 0 {
+			kv = append(kv, TTLAttribute.Float64(attrs.TTL.Seconds()))
+		}
+		if attrs.Size != 0 {
+			kv = append(kv, SizeAttribute.Int(attrs.Size))
+		}
+		if attrs.StatusCode != 0 {
+			kv = append(kv, StatusCodeAttribute.Int(attrs.StatusCode))
+		}
+		if attrs.Shared {
+			kv = append(kv, SharedAttribute.Bool(true))
+		}
+		s.SetAttributes(kv...)
+	}
+	s.Span.End()
+}
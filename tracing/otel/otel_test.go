@@ -0,0 +1,145 @@
+package otel
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	cache "github.com/victorspringer/http-cache"
+	"github.com/victorspringer/http-cache/adapter/memory"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newTracedClient(t *testing.T, opts ...cache.ClientOption) (*cache.Client, *tracetest.SpanRecorder, trace.Tracer) {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	tracer, err := New(WithTracerProvider(provider))
+	if err != nil {
+		t.Fatal(err)
+	}
+	adapter, err := memory.NewAdapter(memory.AdapterWithAlgorithm(memory.LRU), memory.AdapterWithCapacity(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := cache.NewClient(append([]cache.ClientOption{
+		cache.ClientWithAdapter(adapter),
+		cache.ClientWithTTL(time.Minute),
+		cache.ClientWithTracer(tracer),
+	}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, recorder, provider.Tracer("test")
+}
+
+func attributes(s sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	m := map[attribute.Key]attribute.Value{}
+	for _, kv := range s.Attributes() {
+		m[kv.Key] = kv.Value
+	}
+	return m
+}
+
+func TestTracerSpans(t *testing.T) {
+	client, recorder, tracer := newTracedClient(t)
+
+	var originParent trace.SpanID
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		originParent = trace.SpanContextFromContext(r.Context()).SpanID()
+		w.Write([]byte("body"))
+	}))
+
+	for i := 0; i < 2; i++ {
+		ctx, root := tracer.Start(context.Background(), "request")
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://x/traced", nil).WithContext(ctx))
+		root.End()
+	}
+
+	byName := map[string][]sdktrace.ReadOnlySpan{}
+	for _, s := range recorder.Ended() {
+		byName[s.Name()] = append(byName[s.Name()], s)
+	}
+	if n := len(byName[cache.SpanLookup]); n != 2 {
+		t.Fatalf("%d lookup spans, want 2", n)
+	}
+	if n := len(byName[cache.SpanOrigin]); n != 1 {
+		t.Fatalf("%d origin spans, want 1", n)
+	}
+	if n := len(byName[cache.SpanStore]); n != 1 {
+		t.Fatalf("%d store spans, want 1", n)
+	}
+
+	miss, hit := attributes(byName[cache.SpanLookup][0]), attributes(byName[cache.SpanLookup][1])
+	if miss[EventAttribute].AsString() != "miss" || hit[EventAttribute].AsString() != "hit" {
+		t.Errorf("lookup events = %q, %q; want miss, hit", miss[EventAttribute].AsString(), hit[EventAttribute].AsString())
+	}
+	if hit[SizeAttribute].AsInt64() != 4 || hit[TTLAttribute].AsFloat64() <= 0 || hit[KeyAttribute].AsString() == "" {
+		t.Errorf("hit attributes = %v, want key, size 4 and a positive ttl", hit)
+	}
+
+	origin := byName[cache.SpanOrigin][0]
+	if originParent != origin.SpanContext().SpanID() {
+		t.Error("origin handler context does not carry the origin span")
+	}
+	if attributes(origin)[StatusCodeAttribute].AsInt64() != http.StatusOK {
+		t.Errorf("origin attributes = %v, want status code 200", attributes(origin))
+	}
+	if byName[cache.SpanStore][0].Parent().SpanID() != byName["request"][0].SpanContext().SpanID() {
+		t.Error("store span is not a child of the request span")
+	}
+}
+
+func TestTracerLinksBackgroundRefresh(t *testing.T) {
+	client, recorder, tracer := newTracedClient(t, cache.ClientWithTTL(time.Millisecond), cache.ClientWithStaleWhileRevalidate(time.Minute))
+
+	type ctxKey struct{}
+	values := make(chan interface{}, 2)
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		values <- r.Context().Value(ctxKey{})
+		w.Write([]byte("body"))
+	}))
+
+	ctx := context.WithValue(context.Background(), ctxKey{}, "value")
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://x/refresh", nil).WithContext(ctx))
+	<-values
+	time.Sleep(5 * time.Millisecond)
+
+	reqCtx, cancel := context.WithCancel(ctx)
+	reqCtx, root := tracer.Start(reqCtx, "request")
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://x/refresh", nil).WithContext(reqCtx))
+	cancel()
+	root.End()
+
+	select {
+	case v := <-values:
+		if v != "value" {
+			t.Errorf("refresh context value = %v, want the request context value", v)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("background refresh never ran")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, s := range recorder.Ended() {
+			if s.Name() != cache.SpanRefresh {
+				continue
+			}
+			if s.Parent().IsValid() {
+				t.Error("refresh span has a parent, want a new root")
+			}
+			if links := s.Links(); len(links) != 1 || links[0].SpanContext.SpanID() != root.SpanContext().SpanID() {
+				t.Errorf("refresh span links = %v, want the request span", links)
+			}
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("refresh span never ended")
+}
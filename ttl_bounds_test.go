@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAdjustTTLJitter(t *testing.T) {
+	client := &Client{
+		ttlJitterFraction: 0.1,
+		ttlJitterDuration: 5 * time.Second,
+	}
+
+	seen := map[time.Duration]bool{}
+	for i := 0; i < 200; i++ {
+		ttl := client.adjustTTL(100*time.Second, false)
+		if ttl < 100*time.Second || ttl > 115*time.Second {
+			t.Fatalf("jittered ttl = %v, want within [100s, 115s]", ttl)
+		}
+		seen[ttl] = true
+	}
+	if len(seen) < 2 {
+		t.Fatal("jitter produced a single ttl value")
+	}
+
+	if got := client.adjustTTL(0, false); got != 0 {
+		t.Errorf("jittered no-expiration ttl = %v, want 0", got)
+	}
+}
+
+func TestAdjustTTLBounds(t *testing.T) {
+	client := &Client{minTTL: 10 * time.Second, maxTTL: 1 * time.Hour}
+
+	tests := []struct {
+		name       string
+		ttl        time.Duration
+		fromOrigin bool
+		want       time.Duration
+	}{
+		{"within bounds", 5 * time.Minute, false, 5 * time.Minute},
+		{"below floor", 1 * time.Second, false, 10 * time.Second},
+		{"above ceiling", 365 * 24 * time.Hour, true, 1 * time.Hour},
+		{"origin max-age=0", 0, true, 10 * time.Second},
+		{"configured no expiration", 0, false, 1 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := client.adjustTTL(tt.ttl, tt.fromOrigin); got != tt.want {
+				t.Errorf("adjustTTL(%v, %v) = %v, want %v", tt.ttl, tt.fromOrigin, got, tt.want)
+			}
+		})
+	}
+}
+
+// An origin sending an extreme max-age must be kept within the bounds.
+func TestClientWithTTLBoundsClampsCacheControl(t *testing.T) {
+	client, err := NewClient(
+		ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+		ClientWithTTL(1*time.Minute),
+		ClientWithRespectCacheControl(),
+		ClientWithTTLBounds(5*time.Second, 1*time.Hour),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := http.Header{}
+	header.Set("Cache-Control", "max-age=31536000")
+	if got := client.responseTTL(header, http.StatusOK); got != 1*time.Hour {
+		t.Errorf("ttl for max-age=31536000 = %v, want 1h", got)
+	}
+	header.Set("Cache-Control", "max-age=0")
+	if got := client.responseTTL(header, http.StatusOK); got != 5*time.Second {
+		t.Errorf("ttl for max-age=0 = %v, want 5s", got)
+	}
+}
+
+func TestTTLJitterAndBoundsRejectInvalidValues(t *testing.T) {
+	for _, opt := range []ClientOption{
+		ClientWithTTLJitter(-0.1),
+		ClientWithTTLJitter(1.5),
+		ClientWithTTLJitterDuration(-time.Second),
+		ClientWithTTLBounds(-time.Second, 0),
+		ClientWithTTLBounds(time.Hour, time.Minute),
+	} {
+		if _, err := NewClient(ClientWithAdapter(&adapterMock{}), ClientWithTTL(time.Minute), opt); err == nil {
+			t.Error("NewClient() error = nil, want error")
+		}
+	}
+}
+
+// Jitter is applied inside the bounds: entries clamped to the ceiling
+// must still get distinct TTLs.
+func TestAdjustTTLJitterAtCeiling(t *testing.T) {
+	client := &Client{
+		minTTL:            10 * time.Second,
+		maxTTL:            time.Hour,
+		ttlJitterDuration: time.Minute,
+	}
+
+	seen := map[time.Duration]bool{}
+	for i := 0; i < 200; i++ {
+		ttl := client.adjustTTL(24*time.Hour, true)
+		if ttl < time.Hour-time.Minute || ttl > time.Hour {
+			t.Fatalf("jittered ttl = %v, want within [59m, 1h]", ttl)
+		}
+		seen[ttl] = true
+	}
+	if len(seen) < 2 {
+		t.Fatal("jitter produced a single ttl value at the ceiling")
+	}
+}
+
+// Without a floor, an origin max-age=0 must neither be stored nor turn
+// into an entry that never expires.
+func TestOriginMaxAgeZeroIsNotStored(t *testing.T) {
+	adapter := &adapterMock{store: map[uint64][]byte{}}
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(time.Minute),
+		ClientWithRespectCacheControl(),
+		ClientWithTTLBounds(0, time.Hour),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := http.Header{}
+	header.Set("Cache-Control", "max-age=0")
+	if got := client.storeSkipReason(header, true, false, http.StatusOK); got != ReasonCacheControl {
+		t.Errorf("storeSkipReason() = %q, want %q", got, ReasonCacheControl)
+	}
+	if response := client.newResponse("http://x/", header, nil, http.StatusOK, nil, 0); response.Expiration.IsZero() || response.Valid() {
+		t.Errorf("max-age=0 entry expiration = %v, want expired", response.Expiration)
+	}
+
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=0")
+		w.Write([]byte("fresh"))
+	}))
+	get(handler, "http://x/")
+	if len(adapter.store) != 0 {
+		t.Errorf("adapter holds %d entries, want 0", len(adapter.store))
+	}
+}
@@ -0,0 +1,399 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package warmer replays a list of URLs against a server running the
+// http-cache middleware so its cache is populated before real traffic
+// arrives. URL lists can be sitemaps, plain newline-separated lists or
+// JSON Lines targets carrying per-URL headers for vary variants.
+package warmer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxSitemapDepth bounds how many sitemap index levels Load follows.
+const maxSitemapDepth = 3
+
+// ErrSitemapIndex is returned by Read for a sitemap index, whose nested
+// sitemaps have to be fetched; use Load to follow them.
+var ErrSitemapIndex = errors.New("warmer: sitemap index, use Load to follow nested sitemaps")
+
+// Target is a request to replay.
+type Target struct {
+	// URL is the absolute URL to request.
+	URL string `json:"url"`
+
+	// Method is the HTTP method. Defaults to GET.
+	Method string `json:"method,omitempty"`
+
+	// Headers are sent with the request on top of Options.Header, e.g.
+	// to select a vary variant.
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// Options is used to set Run settings.
+type Options struct {
+	// Client sends the requests. Defaults to http.DefaultClient.
+	Client *http.Client
+
+	// Concurrency is the number of requests in flight. Defaults to 1.
+	Concurrency int
+
+	// Header is sent with every request.
+	Header http.Header
+
+	// Variants replays every target once per header set, e.g. one per
+	// Accept-Language value the middleware varies on. A nil or empty
+	// slice replays every target once.
+	Variants []http.Header
+
+	// BaseURL, when set, replaces the scheme and host of every target so
+	// a sitemap for the public site can warm a single instance.
+	BaseURL string
+
+	// StatusHeader is the response header carrying the cache status,
+	// e.g. X-Cache, sent by a middleware with ClientWithDebugHeaders when
+	// Header carries the debug secret. Results have no cache status when
+	// it is not set.
+	StatusHeader string
+}
+
+// Result is the outcome of replaying one target.
+type Result struct {
+	// URL is the requested URL, after BaseURL rewriting.
+	URL string
+
+	// Header is the variant header set the request was sent with.
+	Header http.Header
+
+	// StatusCode is the response status code.
+	StatusCode int
+
+	// Cache is the value of the status header (HIT, MISS, ...), empty
+	// when the response did not carry it or Options.StatusHeader is not
+	// set.
+	Cache string
+
+	// Duration is how long the request took.
+	Duration time.Duration
+
+	// Err is the request error, if any.
+	Err error
+}
+
+// Read parses a URL list. The format is detected from the content: an
+// XML sitemap (urlset), JSON Lines with one Target per line, or plain
+// text with one URL per line. Blank lines and lines starting with # are
+// skipped.
+func Read(r io.Reader) ([]Target, error) {
+	targets, sitemaps, err := parse(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(sitemaps) > 0 {
+		return nil, ErrSitemapIndex
+	}
+	return targets, nil
+}
+
+// Load reads a URL list from src, which is a file path, "-" for standard
+// input, or an http(s) URL fetched with client. Sitemap indexes are
+// followed up to a few levels deep; their nested sitemaps must be http(s)
+// URLs, so a remote index cannot make Load read local files.
+func Load(ctx context.Context, client *http.Client, src string) ([]Target, error) {
+	return load(ctx, client, src, 0)
+}
+
+func load(ctx context.Context, client *http.Client, src string, depth int) ([]Target, error) {
+	rc, err := open(ctx, client, src)
+	if err != nil {
+		return nil, err
+	}
+	targets, sitemaps, err := parse(rc)
+	rc.Close()
+	if err != nil {
+		return nil, fmt.Errorf("warmer: %s: %w", src, err)
+	}
+	if len(sitemaps) > 0 && depth >= maxSitemapDepth {
+		return nil, fmt.Errorf("warmer: %s: sitemap indexes nested deeper than %d levels", src, maxSitemapDepth)
+	}
+	for _, sitemap := range sitemaps {
+		if !remote(sitemap) {
+			return nil, fmt.Errorf("warmer: %s: nested sitemap %q is not an http(s) URL", src, sitemap)
+		}
+		nested, err := load(ctx, client, sitemap, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, nested...)
+	}
+	return targets, nil
+}
+
+func open(ctx context.Context, client *http.Client, src string) (io.ReadCloser, error) {
+	if src == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	if !remote(src) {
+		return os.Open(src)
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("warmer: fetching %s: %s", src, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// remote reports whether src is an http(s) URL rather than a file path.
+func remote(src string) bool {
+	return strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://")
+}
+
+type sitemapDocument struct {
+	XMLName xml.Name
+	URLs    []sitemapLoc `xml:"url"`
+	Nested  []sitemapLoc `xml:"sitemap"`
+}
+
+type sitemapLoc struct {
+	Loc string `xml:"loc"`
+}
+
+// parse returns the targets in r and, for a sitemap index, the nested
+// sitemap locations.
+func parse(r io.Reader) ([]Target, []string, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	trimmed := bytes.TrimSpace(b)
+	if len(trimmed) == 0 {
+		return nil, nil, nil
+	}
+
+	switch trimmed[0] {
+	case '<':
+		var doc sitemapDocument
+		if err := xml.Unmarshal(trimmed, &doc); err != nil {
+			return nil, nil, err
+		}
+		var (
+			targets  []Target
+			sitemaps []string
+		)
+		for _, u := range doc.URLs {
+			if loc := strings.TrimSpace(u.Loc); loc != "" {
+				targets = append(targets, Target{URL: loc})
+			}
+		}
+		for _, s := range doc.Nested {
+			if loc := strings.TrimSpace(s.Loc); loc != "" {
+				sitemaps = append(sitemaps, loc)
+			}
+		}
+		return targets, sitemaps, nil
+	case '{':
+		var targets []Target
+		scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+		for line := 1; scanner.Scan(); line++ {
+			text := bytes.TrimSpace(scanner.Bytes())
+			if len(text) == 0 {
+				continue
+			}
+			var t Target
+			if err := json.Unmarshal(text, &t); err != nil {
+				return nil, nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			if t.URL == "" {
+				return nil, nil, fmt.Errorf("line %d: url is not set", line)
+			}
+			targets = append(targets, t)
+		}
+		return targets, nil, scanner.Err()
+	default:
+		var targets []Target
+		scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+		for scanner.Scan() {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" || strings.HasPrefix(text, "#") {
+				continue
+			}
+			targets = append(targets, Target{URL: text})
+		}
+		return targets, nil, scanner.Err()
+	}
+}
+
+// Run replays targets, once per variant, and returns one Result per
+// request in input order. Run stops sending new requests when ctx is
+// done; the remaining results carry ctx's error.
+func Run(ctx context.Context, targets []Target, opts Options) []Result {
+	client := opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	variants := opts.Variants
+	if len(variants) == 0 {
+		variants = []http.Header{nil}
+	}
+
+	type job struct {
+		index   int
+		target  Target
+		variant http.Header
+	}
+	results := make([]Result, len(targets)*len(variants))
+	jobs := make(chan job)
+
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				results[j.index] = replay(ctx, client, j.target, j.variant, opts)
+			}
+		}()
+	}
+
+	i := 0
+feed:
+	for _, t := range targets {
+		for _, v := range variants {
+			select {
+			case jobs <- job{index: i, target: t, variant: v}:
+			case <-ctx.Done():
+				break feed
+			}
+			i++
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	for ; i < len(results); i++ {
+		t := targets[i/len(variants)]
+		results[i] = Result{URL: t.URL, Header: variants[i%len(variants)], Err: ctx.Err()}
+	}
+	return results
+}
+
+func replay(ctx context.Context, client *http.Client, t Target, variant http.Header, opts Options) Result {
+	result := Result{URL: t.URL, Header: variant}
+	u, err := rebase(t.URL, opts.BaseURL)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.URL = u
+
+	method := t.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	for k, vs := range opts.Header {
+		req.Header[k] = append([]string(nil), vs...)
+	}
+	for k, vs := range variant {
+		req.Header[k] = append([]string(nil), vs...)
+	}
+	for k, v := range t.Headers {
+		req.Header.Set(k, v)
+	}
+	if host := req.Header.Get("Host"); host != "" {
+		req.Host = host
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		result.Err = err
+		result.Duration = time.Since(start)
+		return result
+	}
+	// Drain the body so the connection is reused and the request is
+	// measured end to end.
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	result.Duration = time.Since(start)
+	result.StatusCode = resp.StatusCode
+	if opts.StatusHeader != "" {
+		result.Cache = resp.Header.Get(opts.StatusHeader)
+	}
+	return result
+}
+
+// rebase replaces the scheme and host of raw with the ones of base.
+func rebase(raw, base string) (string, error) {
+	if base == "" {
+		return raw, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	b, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	u.Scheme = b.Scheme
+	u.Host = b.Host
+	if p := strings.TrimSuffix(b.Path, "/"); p != "" {
+		u.Path = p + u.Path
+	}
+	return u.String(), nil
+}
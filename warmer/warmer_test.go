@@ -0,0 +1,169 @@
+package warmer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []Target
+	}{
+		{
+			"sitemap",
+			`<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>https://example.com/</loc></url>
+  <url><loc> https://example.com/products </loc><lastmod>2024-01-01</lastmod></url>
+</urlset>`,
+			[]Target{{URL: "https://example.com/"}, {URL: "https://example.com/products"}},
+		},
+		{
+			"url list",
+			"# warm these\nhttps://example.com/a\n\n  https://example.com/b  \n",
+			[]Target{{URL: "https://example.com/a"}, {URL: "https://example.com/b"}},
+		},
+		{
+			"json lines",
+			`{"url": "https://example.com/a"}
+{"url": "https://example.com/a", "headers": {"Accept-Language": "pt-BR"}}
+{"url": "https://example.com/search", "method": "POST"}`,
+			[]Target{
+				{URL: "https://example.com/a"},
+				{URL: "https://example.com/a", Headers: map[string]string{"Accept-Language": "pt-BR"}},
+				{URL: "https://example.com/search", Method: "POST"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Read(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Read() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadRejectsSitemapIndexAndInvalidJSONLines(t *testing.T) {
+	index := `<sitemapindex><sitemap><loc>https://example.com/s1.xml</loc></sitemap></sitemapindex>`
+	if _, err := Read(strings.NewReader(index)); !errors.Is(err, ErrSitemapIndex) {
+		t.Errorf("Read(index) error = %v, want ErrSitemapIndex", err)
+	}
+	if _, err := Read(strings.NewReader(`{"method": "GET"}`)); err == nil {
+		t.Error("Read() of a JSON line without url error = nil, want error")
+	}
+}
+
+func TestLoadFollowsSitemapIndex(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sitemap.xml":
+			fmt.Fprintf(w, `<sitemapindex><sitemap><loc>%s/a.xml</loc></sitemap><sitemap><loc>%s/b.xml</loc></sitemap></sitemapindex>`, srv.URL, srv.URL)
+		case "/a.xml":
+			fmt.Fprint(w, `<urlset><url><loc>https://example.com/a</loc></url></urlset>`)
+		case "/b.xml":
+			fmt.Fprint(w, `<urlset><url><loc>https://example.com/b</loc></url></urlset>`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	got, err := Load(context.Background(), srv.Client(), srv.URL+"/sitemap.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Target{{URL: "https://example.com/a"}, {URL: "https://example.com/b"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Load() = %v, want %v", got, want)
+	}
+}
+
+// A remote sitemap index cannot point Load at local files.
+func TestLoadRejectsLocalNestedSitemaps(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<sitemapindex><sitemap><loc>/etc/passwd</loc></sitemap></sitemapindex>`)
+	}))
+	defer srv.Close()
+
+	if got, err := Load(context.Background(), srv.Client(), srv.URL+"/sitemap.xml"); err == nil {
+		t.Errorf("Load() = %v, want an error for a local nested sitemap", got)
+	}
+}
+
+func TestRunReportsCacheStatusPerVariant(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		seen = map[string]bool{}
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token") != "secret" {
+			t.Errorf("request without the common header: %v", r.Header)
+		}
+		k := r.URL.Path + "|" + r.Header.Get("Accept-Language")
+		mu.Lock()
+		if seen[k] {
+			w.Header().Set("X-Cache", "HIT")
+		} else {
+			w.Header().Set("X-Cache", "MISS")
+		}
+		seen[k] = true
+		mu.Unlock()
+		fmt.Fprint(w, "ok")
+	}))
+	defer srv.Close()
+
+	targets := []Target{{URL: "https://example.com/a"}, {URL: "https://example.com/a"}}
+	results := Run(context.Background(), targets, Options{
+		Client:      srv.Client(),
+		Concurrency: 1,
+		Header:      http.Header{"X-Token": {"secret"}},
+		Variants: []http.Header{
+			{"Accept-Language": {"en"}},
+			{"Accept-Language": {"pt-BR"}},
+		},
+		BaseURL:      srv.URL,
+		StatusHeader: "X-Cache",
+	})
+
+	want := []string{"MISS", "MISS", "HIT", "HIT"}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(results), len(want))
+	}
+	for i, r := range results {
+		if r.Err != nil {
+			t.Fatalf("result %d error = %v", i, r.Err)
+		}
+		if r.Cache != want[i] || r.StatusCode != http.StatusOK {
+			t.Errorf("result %d = %s %d, want %s 200", i, r.Cache, r.StatusCode, want[i])
+		}
+		if r.URL != srv.URL+"/a" {
+			t.Errorf("result %d URL = %s, want it rebased on the test server", i, r.URL)
+		}
+	}
+}
+
+func TestRunStopsWhenContextIsDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results := Run(ctx, []Target{{URL: "http://127.0.0.1:1/a"}, {URL: "http://127.0.0.1:1/b"}}, Options{})
+	for i, r := range results {
+		if !errors.Is(r.Err, context.Canceled) {
+			t.Errorf("result %d error = %v, want context.Canceled", i, r.Err)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+/*
+MIT License
+
+Copyright (c) 2018 Victor Springer
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package cache
+
+import (
+	"context"
+	"encoding/hex"
+	"time"
+)
+
+// WideKeyAdapter is an optional Adapter extension for adapters that can
+// key entries by the request fingerprint itself, a SHA-256 hash of the
+// canonical request, instead of its 64-bit FNV hash. Two requests whose
+// uint64 keys collide then no longer share a slot and evict each other.
+// The key is the hex-encoded fingerprint, 64 characters long. The client
+// only uses it with ClientWithWideKeys; otherwise, and for direct callers,
+// the adapter keeps being used through its uint64 methods.
+type WideKeyAdapter interface {
+	// GetWide retrieves the cached response by a given key. A missing
+	// key is not an error: it returns false and a nil error.
+	GetWide(ctx context.Context, key string) ([]byte, bool, error)
+
+	// SetWide caches a response for a given key until an expiration
+	// date.
+	SetWide(ctx context.Context, key string, response []byte, expiration time.Time) error
+
+	// ReleaseWide frees cache for a given key.
+	ReleaseWide(ctx context.Context, key string) error
+}
+
+// AdapterTouchWide is AdapterTouch for the keys of a WideKeyAdapter.
+type AdapterTouchWide interface {
+	TouchWide(key string)
+}
+
+// AdapterWideKeys is AdapterKeys for the keys of a WideKeyAdapter.
+// AdminHandler lists and purges them next to the uint64 ones.
+type AdapterWideKeys interface {
+	WideKeys() []string
+}
+
+// ClientWithWideKeys stores entries through the WideKeyAdapter methods of
+// the adapter, keyed by the request fingerprint. NewClient fails when the
+// adapter does not implement WideKeyAdapter. Entries stored under uint64
+// keys are not looked up anymore: enabling the option starts with an
+// empty cache. Optional setting.
+func ClientWithWideKeys() ClientOption {
+	return func(c *Client) error {
+		c.wideKeys = true
+		return nil
+	}
+}
+
+// takesWideKeys reports whether a and every adapter it wraps implement
+// WideKeyAdapter.
+func takesWideKeys(a Adapter) bool {
+	for v := interface{}(a); v != nil; v = unwrapAdapter(v) {
+		if _, ok := v.(WideKeyAdapter); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// wideKey returns the WideKeyAdapter key for fingerprint, or an empty
+// string when the client uses uint64 keys.
+func (c *Client) wideKey(fingerprint []byte) string {
+	if !c.wideKeys || len(fingerprint) == 0 {
+		return ""
+	}
+	return hex.EncodeToString(fingerprint)
+}
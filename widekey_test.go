@@ -0,0 +1,233 @@
+package cache
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// wideAdapter is a WideKeyAdapter whose uint64 methods map every key to
+// the same slot, so any use of them shows up as a collision.
+type wideAdapter struct {
+	fixedKeyAdapter
+	mu   sync.Mutex
+	wide map[string][]byte
+}
+
+func (a *wideAdapter) GetWide(ctx context.Context, key string) ([]byte, bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	b, ok := a.wide[key]
+	return b, ok, nil
+}
+
+func (a *wideAdapter) SetWide(ctx context.Context, key string, response []byte, expiration time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.wide[key] = response
+	return nil
+}
+
+func (a *wideAdapter) ReleaseWide(ctx context.Context, key string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.wide, key)
+	return nil
+}
+
+func (a *wideAdapter) WideKeys() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	keys := make([]string, 0, len(a.wide))
+	for k := range a.wide {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Requests whose uint64 keys collide keep their own entries when the
+// adapter takes wide keys.
+func TestMiddlewareUsesWideKeys(t *testing.T) {
+	adapter := &wideAdapter{wide: map[string][]byte{}}
+	client, err := NewClient(ClientWithAdapter(adapter), ClientWithTTL(time.Minute), ClientWithWideKeys())
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte("response for " + r.URL.Path))
+	}))
+
+	for i := 0; i < 2; i++ {
+		for _, p := range []string{"/user-a", "/user-b"} {
+			if got, want := get(handler, "http://x"+p), "response for "+p; got != want {
+				t.Errorf("GET %s = %q, want %q", p, got, want)
+			}
+		}
+	}
+	if calls != 2 {
+		t.Errorf("origin calls = %d, want 2", calls)
+	}
+	if adapter.blob != nil {
+		t.Error("the middleware used the uint64 key of a WideKeyAdapter")
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "http://x/user-a", nil)
+	_, fingerprint, err := client.requestKey(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wide := hex.EncodeToString(fingerprint); adapter.wide[wide] == nil {
+		t.Errorf("no entry stored under the hex fingerprint %q", wide)
+	}
+
+	entry, err := client.Lookup(context.Background(), r)
+	if err != nil || string(entry.Response.Value) != "response for /user-a" {
+		t.Errorf("Lookup() = %q, %v", entry.Response.Value, err)
+	}
+	if err := client.Drop(r); err != nil {
+		t.Fatal(err)
+	}
+	if len(adapter.wide) != 1 {
+		t.Errorf("Drop() left %d entries, want 1", len(adapter.wide))
+	}
+}
+
+// With wide keys, requests whose uint64 keys collide are not coalesced
+// with each other. FNV hashes the URL and body of a POST without a
+// separator, so /a with body "bc" and /ab with body "c" share a key.
+func TestSingleflightUsesWideKeys(t *testing.T) {
+	adapter := &wideAdapter{wide: map[string][]byte{}}
+	var (
+		mu     sync.Mutex
+		events []CacheEvent
+	)
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(time.Minute),
+		ClientWithMethods([]string{http.MethodGet, http.MethodPost}),
+		ClientWithSingleflight(),
+		ClientWithWideKeys(),
+		ClientWithObserver(func(e CacheEvent) {
+			mu.Lock()
+			events = append(events, e)
+			mu.Unlock()
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := httptest.NewRequest(http.MethodPost, "http://x/a", strings.NewReader("bc"))
+	b := httptest.NewRequest(http.MethodPost, "http://x/ab", strings.NewReader("c"))
+	keyA, _, _ := client.requestKey(a)
+	keyB, _, _ := client.requestKey(b)
+	if keyA != keyB {
+		t.Fatalf("keys %d and %d differ, want a collision", keyA, keyB)
+	}
+
+	var arrived sync.WaitGroup
+	arrived.Add(2)
+	bothArrived := make(chan struct{})
+	go func() {
+		arrived.Wait()
+		close(bothArrived)
+	}()
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		arrived.Done()
+		select {
+		case <-bothArrived:
+		case <-time.After(time.Second):
+		}
+		w.Write([]byte("response for " + r.URL.Path + " " + string(body)))
+	}))
+
+	var wg sync.WaitGroup
+	got := make([]string, 2)
+	for i, r := range []*http.Request{a, b} {
+		wg.Add(1)
+		go func(i int, r *http.Request) {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			got[i] = w.Body.String()
+		}(i, r)
+	}
+	wg.Wait()
+	if got[0] != "response for /a bc" || got[1] != "response for /ab c" {
+		t.Errorf("responses = %q, want each request its own body", got)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, e := range events {
+		if len(e.WideKey) != 64 {
+			t.Errorf("%s event WideKey = %q, want the hex fingerprint", e.Type, e.WideKey)
+		}
+	}
+}
+
+func TestAdminHandlerListsWideKeys(t *testing.T) {
+	_, admin := newAdminTestClient(t, &wideAdapter{wide: map[string][]byte{}}, ClientWithWideKeys())
+
+	w := serveAdmin(admin, http.MethodGet, "/keys?prefix="+url.QueryEscape("http://x/a/"))
+	var keys struct {
+		Keys []adminEntry `json:"keys"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &keys); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys.Keys) != 2 || len(keys.Keys[0].Key) != 64 {
+		t.Errorf("GET /keys?prefix = %+v, want 2 entries with wide keys", keys.Keys)
+	}
+
+	w = serveAdmin(admin, http.MethodGet, "/stats")
+	var stats map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats["entries"] != float64(3) {
+		t.Errorf("stats entries = %v, want 3", stats["entries"])
+	}
+
+	w = serveAdmin(admin, http.MethodPost, "/purge?prefix="+url.QueryEscape("http://x/a/"))
+	if got := w.Body.String(); got != "{\"purged\":2}\n" {
+		t.Errorf("POST /purge?prefix = %s", got)
+	}
+}
+
+// Wide keys are opt-in: without ClientWithWideKeys, a WideKeyAdapter
+// keeps the uint64 keys of the entries it already holds.
+func TestWideKeysAreOptIn(t *testing.T) {
+	adapter := &wideAdapter{wide: map[string][]byte{}}
+	client, err := NewClient(ClientWithAdapter(adapter), ClientWithTTL(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := client.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	get(handler, "http://x/")
+	if adapter.blob == nil || len(adapter.wide) != 0 {
+		t.Error("the middleware used wide keys without ClientWithWideKeys")
+	}
+
+	if _, err := NewClient(
+		ClientWithAdapter(&adapterMock{store: map[uint64][]byte{}}),
+		ClientWithTTL(time.Minute),
+		ClientWithWideKeys(),
+	); err == nil {
+		t.Error("NewClient() with wide keys and a uint64-only adapter error = nil, want error")
+	}
+}